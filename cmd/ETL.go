@@ -55,32 +55,51 @@ Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		setupLogging()
 
-		glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(true)))
-		glogger.Verbosity(log.Lvl(flagAppVerbosity))
-		log.Root().SetHandler(glogger)
-
-		// Set up a shared instance of this client API.
-		c := influxdb2.NewClient(flagInfluxEndpoint, flagInfluxToken)
-		api := c.WriteAPIBlocking(flagInfluxOrg, flagInfluxBucket)
-
-		manWU := &managerWU{
-			apiKey: flagWUAPIKey,
+		var err error
+		location := forecastLocation{key: "geocode", value: flagAppForecastGeocode}
+		if flagAppForecastLocation != "" {
+			location, err = parseForecastLocation(flagAppForecastLocation)
+		} else {
+			err = location.validate()
 		}
-
-		manIF := &managerInflux{
-			clientAPI: api,
-			namespace: "wu2.",
+		if err != nil {
+			log.Crit("Invalid forecast location", "error", err)
+		}
+		if flagAppAirQualityEnable && !flagAppForecastPerStation && location.key != "geocode" {
+			log.Crit("Air quality needs a geocode forecast location", "location", location)
+		}
+		if !isForecastDays(flagAppForecastDays) {
+			log.Crit("Invalid forecast days", "days", flagAppForecastDays, "valid", forecastDays)
+		}
+		if flagAppForecastHourly != "" && !isHourlyForecastProduct(flagAppForecastHourly) {
+			log.Crit("Invalid hourly forecast product", "product", flagAppForecastHourly, "valid", hourlyForecastProducts)
 		}
 
 		rc := &runConfig{
-			manWU:         manWU,
-			managerInflux: manIF,
+			manWU:         newManagerWU(),
+			managerInflux: newManagerInflux(),
 			stations:      flagWUStations,
 			interval:      flagAppInterval,
+			recoverMax:    flagAppRecoverMax,
+			backoffMax:    flagAppBackoffMax,
+			offlineAfter:  flagAppOfflineAfter,
+
+			stationForecasts: map[string]*forecastTarget{},
+		}
+		if !flagAppForecastPerStation {
+			rc.forecastTargets = []*forecastTarget{newForecastTarget(location)}
+		}
+		if err := rc.manWU.budget.checkBudget(rc, rc.manWU.keys.size()); err != nil {
+			log.Crit("API quota would be exceeded", "error", err)
 		}
 
+		rc.managerInflux.checkSchema(flagInfluxSchemaWindow)
+		rc.stop = stopOnSignal()
+		rc.manWU.stop = rc.stop
 		run(rc)
+		rc.managerInflux.close()
 	},
 }
 
@@ -88,37 +107,169 @@ var flagInfluxEndpoint string
 var flagInfluxToken string
 var flagInfluxOrg string
 var flagInfluxBucket string
+var flagInfluxSchemas []string
+var flagInfluxFieldsDrop []string
+var flagInfluxFieldsUnknown string
+var flagInfluxTypeConflicts string
+var flagInfluxSchemaWindow time.Duration
+var flagInfluxBatchSize uint
+var flagInfluxFlushInterval time.Duration
+var flagInfluxMaxRetries uint
+var flagInfluxRetryInterval time.Duration
+var flagInfluxMaxRetryInterval time.Duration
 
 var flagWUStations []string
-var flagWUAPIKey string
+var flagWUAPIKeys []string
+var flagWUEndpoint string
+var flagWUTimeout time.Duration
+var flagWUQuotaDaily int
+var flagWUQuotaMinute int
+var flagWURetryNetwork string
+var flagWURetryAuth string
+var flagWURetryOffline string
+var flagWURetryRateLimited string
+var flagWURetryServer string
 
 var flagAppInterval time.Duration
+var flagAppRecoverMax time.Duration
+var flagAppBackoffMax time.Duration
+var flagAppOfflineAfter time.Duration
 var flagAppVerbosity int
 var flagAppDatadir string
 
 var flagAppForecastEnable bool
 var flagAppForecastGeocode string
-var forecastExpiry time.Time
+var flagAppForecastHourly string
+var flagAppForecastDays int
+var flagAppForecastLocation string
+var flagAppForecastPerStation bool
+var flagAppOfficialEnable bool
+var flagAppAlertsEnable bool
+var flagAppAirQualityEnable bool
+var flagAppAlertsInterval time.Duration
 
 func init() {
 	rootCmd.AddCommand(ETLCmd)
 
 	// Here you will define your flags and configuration settings.
 
-	ETLCmd.PersistentFlags().StringVar(&flagInfluxEndpoint, "influx_endpoint", "", "")
-	ETLCmd.PersistentFlags().StringVar(&flagInfluxToken, "influx_token", "", "user:pass for v1.8")
-	ETLCmd.PersistentFlags().StringVar(&flagInfluxOrg, "influx_org", "", "")
-	ETLCmd.PersistentFlags().StringVar(&flagInfluxBucket, "influx_bucket", "weather/autogen", "Use slashed-delim db/retention for v1.8. Otherwise v2.")
-
-	ETLCmd.PersistentFlags().StringSliceVar(&flagWUStations, "wu_stations", nil, "")
-	ETLCmd.PersistentFlags().StringVar(&flagWUAPIKey, "wu_apikey", "", "")
+	// Influx, WU and app storage flags are shared by all subcommands (eg. backfill),
+	// so they live on the root command.
+	rootCmd.PersistentFlags().StringVar(&flagInfluxEndpoint, "influx_endpoint", "", "")
+	rootCmd.PersistentFlags().StringVar(&flagInfluxToken, "influx_token", "", "user:pass for v1.8")
+	rootCmd.PersistentFlags().StringVar(&flagInfluxOrg, "influx_org", "", "")
+	rootCmd.PersistentFlags().StringVar(&flagInfluxBucket, "influx_bucket", "weather/autogen", "Use slashed-delim db/retention for v1.8. Otherwise v2.")
+	rootCmd.PersistentFlags().StringSliceVar(&flagInfluxSchemas, "influx_schema", []string{schemaV2}, "Schema(s) to write observations in [v2,v3]. v2: a measurement per value. v3: an observation measurement with a field per value. Give both to dual-write.")
+	rootCmd.PersistentFlags().StringSliceVar(&flagInfluxFieldsDrop, "influx_fields_drop", nil, "Observation fields not to write, by path. Eg. metric.elev,imperial.elev")
+	rootCmd.PersistentFlags().StringVar(&flagInfluxFieldsUnknown, "influx_fields_unknown", unknownFieldsWrite, "What to do with observation fields missing from the field registry [write,drop]")
+	rootCmd.PersistentFlags().StringVar(&flagInfluxTypeConflicts, "influx_type_conflicts", typeConflictsSuffix, "What to do with fields whose type in InfluxDB differs from the registry's [refuse,suffix]. suffix writes eg. value_float instead.")
+	rootCmd.PersistentFlags().DurationVar(&flagInfluxSchemaWindow, "influx_schema_window", 30*24*time.Hour, "How far back field types are read at startup (v2 only)")
+	rootCmd.PersistentFlags().UintVar(&flagInfluxBatchSize, "influx_batch_size", 500, "Points written per batch")
+	rootCmd.PersistentFlags().DurationVar(&flagInfluxFlushInterval, "influx_flush_interval", time.Second, "Interval at which a partial batch is written")
+	rootCmd.PersistentFlags().UintVar(&flagInfluxMaxRetries, "influx_max_retries", 5, "Retries of a batch which failed with a 5xx or network error. 0=no retries")
+	rootCmd.PersistentFlags().DurationVar(&flagInfluxRetryInterval, "influx_retry_interval", 5*time.Second, "Wait before the first retry of a batch; it grows exponentially")
+	rootCmd.PersistentFlags().DurationVar(&flagInfluxMaxRetryInterval, "influx_max_retry_interval", 2*time.Minute, "Maximum wait between retries of a batch")
+
+	rootCmd.PersistentFlags().StringSliceVar(&flagWUStations, "wu_stations", nil, "")
+	rootCmd.PersistentFlags().StringSliceVar(&flagWUAPIKeys, "wu_apikey", nil, "API key(s). Calls are spread across several keys, failing over when one is rejected or rate limited.")
+	rootCmd.PersistentFlags().StringVar(&flagWUEndpoint, "wu_endpoint", "https://api.weather.com", "Base URL of the Weather Underground API")
+	rootCmd.PersistentFlags().DurationVar(&flagWUTimeout, "wu_timeout", 30*time.Second, "Timeout for each Weather Underground API request")
+	rootCmd.PersistentFlags().IntVar(&flagWUQuotaDaily, "wu_quota_daily", 0, "API calls allowed per key per day (PWS owner keys: 1500). 0=unlimited")
+	rootCmd.PersistentFlags().StringVar(&flagWURetryNetwork, "wu_retry_network", "3,1s", "Retry policy (tries,backoff) for network errors. Backoff doubles each retry.")
+	rootCmd.PersistentFlags().StringVar(&flagWURetryAuth, "wu_retry_auth", "1,0s", "Retry policy (tries,backoff) for 401/403 responses (bad API key)")
+	rootCmd.PersistentFlags().StringVar(&flagWURetryOffline, "wu_retry_offline", "1,0s", "Retry policy (tries,backoff) for 204 responses (station offline)")
+	rootCmd.PersistentFlags().StringVar(&flagWURetryRateLimited, "wu_retry_rate_limited", "2,1m", "Retry policy (tries,backoff) for 429 responses. Retry-After is honored if longer.")
+	rootCmd.PersistentFlags().StringVar(&flagWURetryServer, "wu_retry_server", "3,5s", "Retry policy (tries,backoff) for 5xx responses")
+	rootCmd.PersistentFlags().IntVar(&flagWUQuotaMinute, "wu_quota_minute", 0, "API calls allowed per key per minute (PWS owner keys: 30). 0=unlimited")
+
+	rootCmd.PersistentFlags().IntVar(&flagAppVerbosity, "app_verbosity", int(log.LvlInfo), "[0..5]")
+	rootCmd.PersistentFlags().StringVar(&flagAppDatadir, "app_datadir", filepath.Join("/var", "lib", "wunderground-influxdb"), "Data directory for persistent storage")
 
 	ETLCmd.PersistentFlags().DurationVar(&flagAppInterval, "app_interval", 32*time.Second, "0=oneshot")
-	ETLCmd.PersistentFlags().IntVar(&flagAppVerbosity, "app_verbosity", int(log.LvlInfo), "[0..5]")
-	ETLCmd.PersistentFlags().StringVar(&flagAppDatadir, "app_datadir", filepath.Join("/var", "lib", "wunderground-influxdb"), "Data directory for persistent storage")
+	ETLCmd.PersistentFlags().DurationVar(&flagAppBackoffMax, "app_backoff_max", time.Hour, "Maximum delay between polls of a failing station")
+	ETLCmd.PersistentFlags().DurationVar(&flagAppOfflineAfter, "app_offline_after", 30*time.Minute, "A station without a new observation for this long is reported offline. 0=disabled")
+	ETLCmd.PersistentFlags().DurationVar(&flagAppRecoverMax, "app_recover_max", 7*24*time.Hour, "On start, recover gaps in observations up to this old. 0=disabled. Not used when oneshot.")
 
 	ETLCmd.PersistentFlags().BoolVar(&flagAppForecastEnable, "app_forecast", false, "Enable forecasting metrics")
-	ETLCmd.PersistentFlags().StringVar(&flagAppForecastGeocode, "app_forecast_geocode", "48.029,-118.367", "Data directory for persistent storage")
+	ETLCmd.PersistentFlags().StringVar(&flagAppForecastGeocode, "app_forecast_geocode", "48.029,-118.367", "Forecast location as lat,lon")
+	ETLCmd.PersistentFlags().StringVar(&flagAppForecastLocation, "app_forecast_location", "", "Forecast location as key=value, overriding the geocode. Keys: geocode, postalKey, iataCode, icaoCode, placeid. Eg. postalKey=99129:US")
+	ETLCmd.PersistentFlags().BoolVar(&flagAppOfficialEnable, "app_official", false, "Enable official current conditions at the forecast location(s), for comparison with the stations")
+	ETLCmd.PersistentFlags().BoolVar(&flagAppAirQualityEnable, "app_airquality", false, "Enable air quality (AQI and pollutants) at the forecast location(s). Needs a geocode location.")
+	ETLCmd.PersistentFlags().BoolVar(&flagAppAlertsEnable, "app_alerts", false, "Enable weather alert events at the forecast location(s)")
+	ETLCmd.PersistentFlags().DurationVar(&flagAppAlertsInterval, "app_alerts_interval", 5*time.Minute, "Interval at which to poll for weather alerts")
+	ETLCmd.PersistentFlags().BoolVar(&flagAppForecastPerStation, "app_forecast_per_station", false, "Forecast at each station's coordinates instead of the forecast geocode")
+	ETLCmd.PersistentFlags().IntVar(&flagAppForecastDays, "app_forecast_days", 5, "Daily forecast horizon in days [3,5,7,10,15]")
+	ETLCmd.PersistentFlags().StringVar(&flagAppForecastHourly, "app_forecast_hourly", "", "Enable hourly forecasting metrics with the given product [2day,15day]")
+}
+
+func setupLogging() {
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(true)))
+	glogger.Verbosity(log.Lvl(flagAppVerbosity))
+	log.Root().SetHandler(glogger)
+}
+
+func newManagerWU() *managerWU {
+	policies, err := parseRetryPolicies(map[string]string{
+		errClassNetwork:     flagWURetryNetwork,
+		errClassAuth:        flagWURetryAuth,
+		errClassOffline:     flagWURetryOffline,
+		errClassRateLimited: flagWURetryRateLimited,
+		errClassServer:      flagWURetryServer,
+	})
+	if err != nil {
+		log.Crit("Invalid retry policy", "error", err)
+	}
+	return &managerWU{
+		keys:          newAPIKeyPool(flagWUAPIKeys),
+		client:        &http.Client{},
+		baseURL:       flagWUEndpoint,
+		timeout:       flagWUTimeout,
+		budget:        newCallBudget(flagWUQuotaDaily, flagWUQuotaMinute),
+		retryPolicies: policies,
+	}
+}
+
+func newManagerInflux() *managerInflux {
+	// Set up a shared instance of this client API.
+	// Points are written in batches, in the background.
+	options := influxdb2.DefaultOptions().
+		SetBatchSize(flagInfluxBatchSize).
+		SetFlushInterval(uint(flagInfluxFlushInterval.Milliseconds())).
+		SetMaxRetries(flagInfluxMaxRetries).
+		SetRetryInterval(uint(flagInfluxRetryInterval.Milliseconds())).
+		SetMaxRetryInterval(uint(flagInfluxMaxRetryInterval.Milliseconds()))
+	schemas, err := parseInfluxSchemas(flagInfluxSchemas)
+	if err != nil {
+		log.Crit("Invalid InfluxDB schema", "error", err)
+	}
+	fields, err := newFieldRegistry(flagInfluxFieldsDrop, flagInfluxFieldsUnknown)
+	if err != nil {
+		log.Crit("Invalid field registry", "error", err)
+	}
+	c := influxdb2.NewClientWithOptions(flagInfluxEndpoint, flagInfluxToken, options)
+	if !isTypeConflictsPolicy(flagInfluxTypeConflicts) {
+		log.Crit("Invalid type conflicts policy", "policy", flagInfluxTypeConflicts, "valid", typeConflictsPolicies)
+	}
+	conflicts := &typeConflicts{}
+	writeErrors := &writeErrors{conflicts: conflicts}
+	api := newBatchWriter(c.WriteAPI(flagInfluxOrg, flagInfluxBucket), writeErrors.handle)
+
+	return &managerInflux{
+		client:    c,
+		clientAPI: api,
+		writer:    api,
+		schemas:   schemas,
+		fields:    fields,
+
+		conflicts:      conflicts,
+		conflictPolicy: flagInfluxTypeConflicts,
+		writeErrors:    writeErrors,
+		endpoint:       flagInfluxEndpoint,
+		token:          flagInfluxToken,
+		org:            flagInfluxOrg,
+		bucket:         flagInfluxBucket,
+		namespace:      "wu2.",
+	}
 }
 
 type runConfig struct {
@@ -126,23 +277,94 @@ type runConfig struct {
 	managerInflux *managerInflux
 	stations      []string
 	interval      time.Duration
+	recoverMax    time.Duration
+
+	// forecastTargets are the locations to forecast.
+	// With per-station forecasts, targets are added as each station's first observation arrives.
+	forecastTargets  []*forecastTarget
+	stationForecasts map[string]*forecastTarget
+
+	// backoffs are keyed by station.
+	backoffs   map[string]*stationBackoff
+	backoffMax time.Duration
+
+	// offlineAfter is how long a station may go without a new observation before it is offline.
+	offlineAfter time.Duration
+
+	// stop is closed to end the run between stations.
+	stop <-chan struct{}
+}
+
+// backoff returns the station's backoff state.
+func (rc *runConfig) backoff(station string) *stationBackoff {
+	if rc.backoffs == nil {
+		rc.backoffs = map[string]*stationBackoff{}
+	}
+	b, ok := rc.backoffs[station]
+	if !ok {
+		b = &stationBackoff{}
+		rc.backoffs[station] = b
+	}
+	return b
 }
 
 type managerWU struct {
-	apiKey string
+	// keys are the API keys, used in turn.
+	keys *apiKeyPool
+
+	// client is used for all API requests.
+	// Proxy and TLS settings belong on its Transport.
+	client  *http.Client
+	baseURL string
+	timeout time.Duration
+
+	// budget accounts for API calls against the keys' quotas, if non-nil.
+	budget *callBudget
+
+	// retryPolicies are keyed by error class.
+	// Classes without a policy use the default.
+	retryPolicies map[string]retryPolicy
+
+	// stop is closed to cut short the waits between tries and for the per-minute quota.
+	stop <-chan struct{}
 }
 
 type managerInflux struct {
 	currentStation string
+	client         influxdb2.Client
 	clientAPI      influxdb2_api.WriteAPIBlocking
 	namespace      string
-}
 
-type weatherUndergroundObservations struct {
-	Observations []interface{} `json:"observations"`
+	// fields is the registry observation values are checked against.
+	// Without one, the default registry is used.
+	fields *fieldRegistry
+
+	// schemas are the schemas observations are written in (see schemaV2).
+	schemas []string
+
+	// writer is the batch writer behind clientAPI, if any.
+	writer *batchWriter
+
+	// writeErrors are reported by the batch writer.
+	writeErrors *writeErrors
+
+	// conflicts are the fields known to have another type in InfluxDB,
+	// handled according to the conflictPolicy.
+	conflicts      *typeConflicts
+	conflictPolicy string
+
+	// Connection settings, used for queries.
+	endpoint string
+	token    string
+	org      string
+	bucket   string
 }
 
 func run(rc *runConfig) {
+	if rc.interval > 0 && rc.recoverMax > 0 {
+		recoverGaps(rc)
+	}
+
 	rerun := true
 
 	// This is a weird wrapper loop thing.
@@ -154,69 +376,141 @@ func run(rc *runConfig) {
 		rerun = rc.interval > 0
 		interval := rc.interval
 
+		cycleStart := time.Now()
+		spacing := rc.stationSpacing()
+
 	stationsLoop:
-		for _, station := range rc.stations {
+		for stationIndex, station := range rc.stations {
+			if stationIndex > 0 && spacing > 0 && !sleepUntilStopped(rc.stop, spacing) {
+				return
+			}
+			if stopped(rc.stop) {
+				return
+			}
 			rc.managerInflux.currentStation = station
 
-			var res *weatherUndergroundObservations
-			var err error
-			try := 0
-			for try < 3 && ((try == 0) || (err != nil && (strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "reset")))) {
-				res, err = rc.manWU.requestCurrent(station)
-				try++
+			backoff := rc.backoff(station)
+			if !backoff.ready(time.Now()) {
+				log.Debug("Station backing off", "station", station, "failures", backoff.failures, "next", backoff.next.Round(time.Second))
+				rc.checkLiveness(station, time.Now())
+				continue stationsLoop
 			}
+
+			res, err := rc.manWU.requestCurrent(station)
 			if err != nil {
-				if strings.Contains(err.Error(), "request failed") {
-					interval = time.Hour // If we encounter an error reading from the API, give it an hour. It could be a rate limit thing.
-				} else {
-					// We assume that the error was a network/network-unreachable error.
-					// This is not our fault (its the internet's fault); not bad API usage, and we should not bump the interval.
+				if errors.Is(err, errStopped) {
+					return
+				}
+				if errors.Is(err, errQuotaExhausted) || errors.Is(err, errNoAPIKey) {
+					// No calls or keys left; every station would fail the same way.
+					log.Error("Request current observation", "station", station, "error", err)
+					break stationsLoop
+				}
+				var retryAfter time.Duration
+				var wuErr *wuError
+				if errors.As(err, &wuErr) {
+					switch wuErr.Class {
+					case errClassRateLimited:
+						// Rate limits apply to the key, not the station.
+						interval = time.Hour // Give the API an hour, unless it told us otherwise.
+						if wuErr.RetryAfter > 0 {
+							interval = wuErr.RetryAfter
+						}
+						break stationsLoop
+					case errClassAuth:
+						// A bad key fails every station.
+						log.Error("API key rejected", "station", station, "code", wuErr.StatusCode)
+						interval = time.Hour
+						break stationsLoop
+					case errClassNetwork:
+						// This is not the station's fault (its the internet's fault),
+						// so skip it this cycle without backing it off.
+						log.Warn("Skipping station this cycle", "station", station, "error", err)
+						rc.checkLiveness(station, time.Now())
+						continue stationsLoop
+					}
+					retryAfter = wuErr.RetryAfter
 				}
-				break stationsLoop
+
+				// Any other error (eg. offline, misconfigured station, server error,
+				// or a response that doesn't decode) only holds back this station.
+				delay := backoff.fail(time.Now(), rc.interval, rc.backoffMax, retryAfter)
+				log.Warn("Station backing off", "station", station, "error", err, "failures", backoff.failures, "delay", delay.Round(time.Second))
+				rc.managerInflux.postAppGauge("backoffFailures", float64(backoff.failures))
+				rc.managerInflux.postAppGauge("backoffSeconds", delay.Seconds())
+				rc.checkLiveness(station, time.Now())
+				continue stationsLoop
+			}
+			if failures := backoff.succeed(); failures > 0 {
+				log.Info("Station recovered from backoff", "station", station, "failures", failures)
+				rc.managerInflux.postAppGauge("backoffFailures", 0)
+				rc.managerInflux.postAppGauge("backoffSeconds", 0)
 			}
+			polled := time.Now()
+			state := getStationState(station)
 			for i, obs := range res.Observations {
 				start := time.Now()
-				err := rc.managerInflux.recordObs(obs)
+				// After a restart the first observation has usually been written already,
+				// but the station still needs its target.
+				if flagAppForecastPerStation {
+					rc.addStationForecastTarget(station, obs)
+				}
+				written, err := rc.managerInflux.recordNewObs(station, state, obs, polled)
 				if err != nil {
 					log.Error("Post InfluxDB observation", "error", err)
 					continue
 				}
+				if !written {
+					continue
+				}
 				log.Info("Posted observation to influx", "i", i, "elapsed", time.Since(start).Round(time.Millisecond))
 			}
+			rc.checkLiveness(station, time.Now())
 		}
 
-		if flagAppForecastEnable {
-			if time.Now().After(forecastExpiry) {
+		rc.runForecasts()
 
-				// Function will set forecastExpiry to the first value given in the forecast data
-				res, err := rc.manWU.requestForecast()
-				if err != nil {
-					log.Error("Forecast request failed", "error", err)
-				} else {
-					// Forecast was received OK.
-					err = rc.managerInflux.recordForecast(res)
-					if err != nil {
-						log.Error("Post InfluxDB forecast", "error", err)
-					} else {
-						// Forecast has been recorded OK.
-						//
-						// Now get the expiry time from the forecast data,
-						// and update our global value.
-						jval := gjson.GetBytes(res, "expirationTimeUtc.0")
-						forecastExpiry = time.Unix(jval.Int(), 0)
-						log.Info("Forecast expiry reset", "expiry", forecastExpiry.Round(time.Second), "expiry.from_now", forecastExpiry.Sub(time.Now()).Round(time.Second))
-					}
-				}
+		now := time.Now()
+		for _, key := range rc.manWU.keys.status() {
+			tags := map[string]string{"apiKey": key.id}
+			healthy := 0.0
+			if key.healthy(now) {
+				healthy = 1
+			}
+			rc.managerInflux.postAppGaugeTagged("apiKeyHealthy", tags, healthy)
+			rc.managerInflux.postAppGaugeTagged("apiKeyFailures", tags, float64(key.failures))
+			if budget := rc.manWU.budget; budget != nil && budget.perDay > 0 {
+				rc.managerInflux.postAppGaugeTagged("quotaRemaining", tags, float64(budget.remaining(key.key)))
 			}
 		}
 
+		if rc.managerInflux.writeErrors != nil {
+			rc.managerInflux.postAppGaugeTagged("influxWriteErrors", nil, float64(rc.managerInflux.writeErrors.total()))
+		}
+
 		if rerun {
+			if spacing > 0 && interval == rc.interval {
+				// The cycle has already spent some of the interval between stations.
+				interval -= time.Since(cycleStart)
+			}
 			log.Warn("Sleeping", "interval", interval)
-			time.Sleep(interval)
+			if !sleepUntilStopped(rc.stop, interval) {
+				return
+			}
 		}
 	}
 }
 
+// stationSpacing returns the delay between station polls which spreads them evenly
+// over the interval, or 0 if polls are not spread.
+// Polls are spread when there is a per-minute API quota.
+func (rc *runConfig) stationSpacing() time.Duration {
+	if rc.manWU.budget == nil || rc.manWU.budget.perMinute <= 0 || len(rc.stations) < 2 {
+		return 0
+	}
+	return rc.interval / time.Duration(len(rc.stations))
+}
+
 type precipStore struct {
 	Latest     float64 `json:"latest"`
 	Cumulative float64 `json:"cumulative"`
@@ -249,37 +543,119 @@ func saveMetricPrecipRealTotal(annotation string, store *precipStore) {
 	}
 }
 
-func (m *managerWU) requestCurrent(station string) (res *weatherUndergroundObservations, err error) {
-	payload := url.Values{}
-	payload.Add("stationId", station)
-	payload.Add("format", "json")
-	payload.Add("units", "m")
-	payload.Add("apiKey", m.apiKey)
-	endpoint := "https://api.weather.com/v2/pws/observations/current?" + payload.Encode()
+// get requests the given API path and returns the response body.
+// The API key is added to the payload.
+// Failed requests are retried according to the retry policy of their error class.
+func (m *managerWU) get(path string, payload url.Values) (data []byte, err error) {
+	return m.getWithRetry(path, payload, false)
+}
+
+// getContent is like get, but an empty response is an offline error,
+// retried according to the offline retry policy.
+func (m *managerWU) getContent(path string, payload url.Values) (data []byte, err error) {
+	return m.getWithRetry(path, payload, true)
+}
+
+func (m *managerWU) getWithRetry(path string, payload url.Values, needContent bool) (data []byte, err error) {
+	for try := 1; ; try++ {
+		data, err = m.getOnce(path, payload, needContent)
+		var wuErr *wuError
+		if err == nil || !errors.As(err, &wuErr) {
+			return data, err
+		}
+		if (wuErr.Class == errClassAuth || wuErr.Class == errClassRateLimited) && m.keys.available(time.Now()) {
+			// The key is out of the rotation now, so try the next one straight away.
+			// This doesn't count as a try; each failover takes a key out, so it ends.
+			log.Warn("Failing over to the next API key", "path", path, "class", wuErr.Class)
+			try--
+			continue
+		}
+		policy := m.retryPolicy(wuErr.Class)
+		if try >= policy.tries {
+			return data, err
+		}
+		wait := policy.wait(try, wuErr.RetryAfter)
+		log.Warn("Retrying request", "path", path, "class", wuErr.Class, "try", try, "wait", wait)
+		if !sleepUntilStopped(m.stop, wait) {
+			return data, err
+		}
+	}
+}
+
+// retryPolicy returns the retry policy for the error class.
+func (m *managerWU) retryPolicy(class string) retryPolicy {
+	if p, ok := m.retryPolicies[class]; ok {
+		return p
+	}
+	return defaultRetryPolicies[class]
+}
+
+func (m *managerWU) getOnce(path string, payload url.Values, needContent bool) ([]byte, error) {
+	key, err := m.keys.pick(time.Now(), m.budget)
+	if err != nil {
+		return nil, err
+	}
+	if m.budget != nil {
+		if err := m.budget.take(key.key, m.stop); err != nil {
+			return nil, err
+		}
+	}
+
+	payload.Set("apiKey", key.key)
+	endpoint := strings.TrimSuffix(m.baseURL, "/") + path + "?" + payload.Encode()
 	requestLogger := log.New("HTTP.GET", endpoint)
 
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	requestStart := time.Now()
-	http.DefaultClient.Timeout = time.Second * 30
-	response, err := http.Get(endpoint)
+	response, err := m.client.Do(request)
 	if err != nil {
 		requestLogger.Error("Request weatherunderground API", "error", err, "elapsed", time.Since(requestStart).Round(time.Millisecond))
-		return nil, err
+		return nil, &wuError{Class: errClassNetwork, Err: err}
 	}
+	defer response.Body.Close()
 	if response.StatusCode >= 300 || response.StatusCode < 200 {
 		requestLogger.Error("Request weatherunderground bad response", "res.code", response.StatusCode, "res", response.Status,
 			"elapsed", time.Since(requestStart).Round(time.Millisecond))
-		return nil, fmt.Errorf("request failed: %d", response.StatusCode)
+		wuErr := newStatusError(response)
+		m.keys.report(key, wuErr, time.Now())
+		return nil, wuErr
+	}
+	m.keys.report(key, nil, time.Now())
+	if response.StatusCode == http.StatusNoContent {
+		// No content is OK for most endpoints (eg. no alerts), but not all;
+		// the caller decides.
+		requestLogger.Info("No content", "elapsed", time.Since(requestStart).Round(time.Millisecond))
+		if needContent {
+			return nil, newStatusError(response)
+		}
+		return nil, nil
 	}
 
 	// Request has been made OK.
 	requestLogger.Info("OK", "elapsed", time.Since(requestStart).Round(time.Millisecond))
 
-	// Decode the response body.
-	data := &weatherUndergroundObservations{}
-	dataBytes, err := ioutil.ReadAll(response.Body)
+	return ioutil.ReadAll(response.Body)
+}
+
+func (m *managerWU) requestCurrent(station string) (res *weatherUndergroundObservations, err error) {
+	payload := url.Values{}
+	payload.Add("stationId", station)
+	payload.Add("format", "json")
+	payload.Add("units", "m")
+	// The API responds 204 No Content when the station is offline.
+	dataBytes, err := m.getContent("/v2/pws/observations/current", payload)
 	if err != nil {
 		return nil, err
 	}
+
+	// Decode the response body.
+	data := &weatherUndergroundObservations{}
 	err = json.Unmarshal(dataBytes, data)
 	if err != nil {
 		return nil, err
@@ -287,148 +663,121 @@ func (m *managerWU) requestCurrent(station string) (res *weatherUndergroundObser
 	return data, nil
 }
 
-func (m *managerWU) requestForecast() (res []byte, err error) {
+// forecastDays are the daily forecast products, /v3/wx/forecast/daily/<N>day.
+var forecastDays = []int{3, 5, 7, 10, 15}
+
+func isForecastDays(days int) bool {
+	for _, d := range forecastDays {
+		if d == days {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *managerWU) requestForecast(location forecastLocation, days int) (res []byte, err error) {
 	payload := url.Values{}
-	payload.Add("geocode", flagAppForecastGeocode)
+	payload.Add(location.key, location.value)
 	payload.Add("format", "json")
 	payload.Add("units", "m")
 	payload.Add("language", "en-US")
-	payload.Add("apiKey", m.apiKey)
-	endpoint := "https://api.weather.com/v3/wx/forecast/daily/5day?" + payload.Encode()
-	requestLogger := log.New("HTTP.GET", endpoint)
+	return m.get(fmt.Sprintf("/v3/wx/forecast/daily/%dday", days), payload)
+}
 
-	requestStart := time.Now()
-	http.DefaultClient.Timeout = time.Second * 30
-	response, err := http.Get(endpoint)
-	if err != nil {
-		requestLogger.Error("Request weatherunderground API", "error", err, "elapsed", time.Since(requestStart).Round(time.Millisecond))
-		return nil, err
+// recordObs writes the observation at the station's observation time.
+// The time the observation was polled from the API is written alongside it (pollEpoch),
+// as is the difference between the two (ingestLag, seconds).
+func (m *managerInflux) recordObs(obs *pwsObservation, polled time.Time) error {
+	if obs == nil {
+		return errors.New("nil observation")
 	}
-	if response.StatusCode >= 300 || response.StatusCode < 200 {
-		requestLogger.Error("Request weatherunderground bad response", "res.code", response.StatusCode, "res", response.Status,
-			"elapsed", time.Since(requestStart).Round(time.Millisecond))
-		return nil, fmt.Errorf("request failed: %d", response.StatusCode)
+	obsTime := obs.time()
+	if obsTime.IsZero() {
+		log.Warn("Observation has no time, using poll time", "station", m.currentStation)
+		obsTime = polled
 	}
 
-	// Request has been made OK.
-	requestLogger.Info("OK", "elapsed", time.Since(requestStart).Round(time.Millisecond))
-
-	// Decode the response body.
-	dataBytes, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
+	tags := obs.tags()
+	gauges := obs.gauges()
+	gauges["pollEpoch"] = float64(polled.Unix())
+	gauges["ingestLag"] = polled.Sub(obsTime).Seconds()
+	blocks := map[string]map[string]interface{}{}
+	for annotation, units := range obs.unitBlocks() {
+		gauges := units.gauges()
+		if units.PrecipTotal != nil {
+			gauges["precipCumulative"] = precipCumulative(annotation+".", *units.PrecipTotal)
+		}
+		blocks[annotation] = gauges
 	}
-	return dataBytes, nil
+	m.postObservation(gauges, blocks, tags, obsTime)
+	return m.postStationMetadata(obs.metadata(), obsTime)
 }
 
-func (m *managerInflux) recordObs(obs interface{}) error {
-	obsT, ok := obs.(map[string]interface{})
-	if !ok {
-		return errors.New("failed to cast observation to map")
+// postStationMetadata writes the station's metadata as the fields of a single
+// <namespace>station.meta measurement, tagged with the current station.
+func (m *managerInflux) postStationMetadata(metadata map[string]interface{}, now time.Time) error {
+	measurement := m.namespace + "station.meta"
+	tags := map[string]string{
+		"stationID": m.currentStation,
+	}
+	pt := influxdb2.NewPoint(measurement, tags, metadata, now)
+	if err := m.clientAPI.WritePoint(context.Background(), pt); err != nil {
+		log.Error("Write point", "error", err)
+		return err
 	}
-	m.postMapRecursive("", obsT)
 	return nil
 }
 
-// observationAnnotationsMustFloat are annotations from the API that we know must be floats.
-// If they are not float, it is BAD if they get posted to Influx, so we need
-// to make sure that WU is giving us proper floats and not some "n/a/" or "--" or whatever.
-// I don't care if these values have duplicates in them, and I am too lazy to go through and weed them;
-// I assembled this list by copy-pasting relevant values from the error(s) commented below.
-var observationAnnotationsMustFloat = []string{
-	"windChill",
-	"temp",
-	"heatIndex",
-	"dewpt",
-	"windSpeed",
-	"windGust",
-	"windSpeed",
-	"windGust",
-	"temp",
-	"heatIndex",
-	"dewpt",
-	"windChill",
-	"windSpeed",
-	"temp",
-	"dewpt",
-	"windGust",
-	"heatIndex",
-	"windChill",
-
-	"humidity",
-	"winddir",
+// precipCumulative returns a running precipitation total which, unlike precipTotal,
+// does not reset at midnight. The running total is persisted in the datadir.
+func precipCumulative(annotation string, precipTotalCurrentValue float64) float64 {
+	store := getMetricPrecipRealTotal(annotation)
+	if store == nil {
+		// Initialize store
+		store = &precipStore{
+			Latest:     precipTotalCurrentValue,
+			Cumulative: precipTotalCurrentValue,
+		}
+	} else {
+		if precipTotalCurrentValue < store.Latest {
+			// We have rolled over (eg midnight reset to 0).
+			// Increment Cumulative value by the prior-latest.
+			store.Cumulative += store.Latest
+		}
+	}
+	store.Latest = precipTotalCurrentValue
+	saveMetricPrecipRealTotal(annotation, store)
+
+	return store.Cumulative + store.Latest
 }
 
-func (m *managerInflux) postMapRecursive(parentAnnotation string, myMap map[string]interface{}) {
-	now := time.Now()
+// postGauges writes each of the gauges as its own <namespace><annotation>.gauge measurement,
+// tagged with the current station and the given tags (if any).
+func (m *managerInflux) postGauges(parentAnnotation string, gauges map[string]interface{}, extraTags map[string]string, now time.Time) {
 	ctx := context.Background()
 	if parentAnnotation != "" {
 		parentAnnotation = parentAnnotation + "."
 	}
 
-	precipTotal, ok := myMap["precipTotal"]
-	if ok {
-		precipTotalCurrentValue, ok := precipTotal.(float64)
-		if !ok {
-			log.Error("Failed to cast precipTotal to float64", "value", precipTotal)
-		} else {
-			store := getMetricPrecipRealTotal(parentAnnotation)
-			if store == nil {
-				// Initialize store
-				store = &precipStore{
-					Latest:     precipTotalCurrentValue,
-					Cumulative: precipTotalCurrentValue,
-				}
-			} else {
-				if precipTotalCurrentValue < store.Latest {
-					// We have rolled over (eg midnight reset to 0).
-					// Increment Cumulative value by the prior-latest.
-					store.Cumulative += store.Latest
-				}
-			}
-			store.Latest = precipTotalCurrentValue
-			saveMetricPrecipRealTotal(parentAnnotation, store)
-
-			myMap["precipCumulative"] = store.Cumulative + store.Latest
-		}
-	}
-
-mapLoop:
-	for k, v := range myMap {
+	for k, v := range gauges {
+		measurement := fmt.Sprintf("%s%s%s.gauge", m.namespace, parentAnnotation, k)
 
-		// Recurse and break if the type is a map "ie 'metric'/'imperial' or whatever
-		switch t := v.(type) {
-		case map[string]interface{}:
-			m.postMapRecursive(k, t)
-			continue mapLoop
-		case []interface{}:
-			// Unhandled; no data.
-			continue mapLoop
+		// The value must be of its field's declared type;
+		// if not (eg. "--" for a number), it is BAD if it gets posted to Influx.
+		value, ok := m.fieldValue(parentAnnotation+k, v)
+		if !ok {
+			continue
 		}
-
-		measurement := fmt.Sprintf("%s%s%s.gauge", m.namespace, parentAnnotation, k)
 		fields := map[string]interface{}{
-			"value": v,
-		}
-
-		// Special handling for annotations that must be floats.
-		for _, annotation := range observationAnnotationsMustFloat {
-			if strings.Contains(measurement, annotation) {
-				fields["value"], ok = v.(float64)
-				if !ok {
-					log.Error("Failed to cast value to float64", "measurement", measurement, "value", v)
-					continue mapLoop
-				}
-
-				// We found an annotation that must be a float;
-				// so we can skip the reset of the cross-reference annotation lookup cases.
-				break
-			}
+			m.fieldKey(measurement, "value", value): value,
 		}
 
 		tags := map[string]string{
 			"stationID": m.currentStation,
 		}
+		for k, v := range extraTags {
+			tags[k] = v
+		}
 
 		pt := influxdb2.NewPoint(measurement, tags, fields, now)
 
@@ -467,23 +816,12 @@ mapLoop:
 				etler_1       | ERROR[12-05|21:55:48.814] Write point                              error="400 Bad Request: partial write: field type conflict: input field \"value\" on measurement \"wu.humidity.gauge\" is type float, already exists as type string dropped=1"
 			*/
 			// It seems Influx does not want to change field data types; it will be very, very hard.
-			// So I'm going to try catching the error and posting as a string instead.
-			if strings.Contains(err.Error(), "field type conflict") &&
-				strings.Contains(err.Error(), "already exists as type string") {
-
-				switch v.(type) {
-				case float64:
-					fields["value"] = fmt.Sprintf("%v", v)
-				}
-
-				// Try again, this time with a stringy value.
-				pt = influxdb2.NewPoint(measurement, tags, fields, now)
-				if err := m.clientAPI.WritePoint(ctx, pt); err != nil {
-					log.Error("Write point again", "error", err)
-				} else {
-					log.Info("Wrote point (second try)", "station", m.currentStation, measurement, v)
-				}
-			}
+			// This used to catch the error and post the value as a string instead,
+			// which quietly turned numbers into strings.
+			// Points are written in batches now, so conflicts don't come back here;
+			// they arrive at writeErrors.handle, which remembers them (see checkSchema).
+			// The first conflicting batch is dropped, and with the suffix policy
+			// later values go to a suffixed field.
 		} else {
 			log.Debug("Wrote point", "station", m.currentStation, measurement, v)
 		}
@@ -523,7 +861,8 @@ var forecastFields = []string{
 	"daypart.0.wxPhraseLong",
 }
 
-func (m *managerInflux) recordForecast(forecastData []byte) error {
+// recordForecast writes the daily forecast, adding the given tags (if any) to each point.
+func (m *managerInflux) recordForecast(forecastData []byte, tags map[string]string) error {
 	now := time.Now()
 	ctx := context.Background()
 
@@ -533,18 +872,41 @@ func (m *managerInflux) recordForecast(forecastData []byte) error {
 	// and it will never be null in the morning.
 	isNight := gjson.GetBytes(forecastData, "daypart.0.narrative.0").Type == gjson.Null
 
+	// The forecast horizon is configurable, so don't assume any number of days.
+	// Day fields have one value per day, and daypart fields one value per day and night.
+	days := len(gjson.GetBytes(forecastData, "validTimeUtc").Array())
+	dayparts := len(gjson.GetBytes(forecastData, "daypart.0.dayOrNight").Array())
+	if days == 0 {
+		return errors.New("forecast has no days")
+	}
+	log.Debug("Forecast layout", "days", days, "dayparts", dayparts, "night", isNight)
+
 	for _, f := range forecastFields {
 		res := gjson.GetBytes(forecastData, f)
 		log.Debug("JSON", f, res.Value())
+		if !res.Exists() {
+			log.Warn("Forecast field missing", "field", f)
+			continue
+		}
 
 		// Create point using fluent style
 		measurementName := fmt.Sprintf("%s/forecast/%s", m.namespace, f)
 		p := influxdb2.NewPointWithMeasurement(measurementName).SetTime(now)
+		for k, v := range tags {
+			p.AddTag(k, v)
+		}
 
 		resSl, ok := res.Value().([]interface{})
 		if !ok {
 			return fmt.Errorf("could not cast field value to slice: %s", f)
 		}
+		want := days
+		if strings.HasPrefix(f, "daypart.") {
+			want = dayparts
+		}
+		if len(resSl) != want {
+			log.Warn("Unexpected forecast field length", "field", f, "len", len(resSl), "want", want)
+		}
 
 		/*
 		   calendarDayTemperatureMax => [15 8 5 4 5 5]
@@ -1,11 +1,18 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
 	"github.com/tidwall/gjson"
 )
 
@@ -31,3 +38,335 @@ func TestTimeAfterZero(t *testing.T) {
 	var initTime time.Time
 	t.Logf("%v", time.Now().After(initTime))
 }
+
+func TestDecodeObservations(t *testing.T) {
+	dataFile := filepath.Join("..", "example-kwafruit1.json")
+
+	b, err := ioutil.ReadFile(dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := &weatherUndergroundObservations{}
+	if err := json.Unmarshal(b, data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Observations) != 1 {
+		t.Fatalf("want 1 observation, got %d", len(data.Observations))
+	}
+	obs := data.Observations[0]
+	if obs.StationID != "KWAFRUIT1" {
+		t.Errorf("stationID: got %q", obs.StationID)
+	}
+	if obs.Epoch != 1634660430 || !obs.ObsTimeUtc.Equal(time.Unix(1634660430, 0)) {
+		t.Errorf("time: got epoch=%d obsTimeUtc=%v", obs.Epoch, obs.ObsTimeUtc)
+	}
+	if obs.UV != nil || obs.SolarRadiation != nil {
+		t.Errorf("want nil uv and solarRadiation")
+	}
+	if obs.Metric == nil || obs.Metric.Pressure == nil || *obs.Metric.Pressure != 1017.95 {
+		t.Errorf("metric.pressure: got %v", obs.Metric)
+	}
+	if obs.Imperial != nil || obs.UKHybrid != nil {
+		t.Errorf("want only metric unit block")
+	}
+	if _, ok := obs.gauges()["uv"]; ok {
+		t.Errorf("null uv should not be a gauge")
+	}
+	for _, k := range []string{"neighborhood", "country", "obsTimeLocal", "lat", "lon"} {
+		if _, ok := obs.gauges()[k]; ok {
+			t.Errorf("metadata %s should not be a gauge", k)
+		}
+		if _, ok := obs.metadata()[k]; !ok {
+			t.Errorf("want %s in metadata", k)
+		}
+	}
+	if _, ok := obs.metadata()["softwareType"]; ok {
+		t.Errorf("null softwareType should not be in metadata")
+	}
+	if tags := obs.tags(); tags["neighborhood"] != "Fruitland" || tags["country"] != "US" {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+}
+
+func TestRequestCurrentMockAPI(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("..", "example-kwafruit1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/pws/observations/current" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("apiKey"); got != "testkey" {
+			t.Errorf("apiKey: got %q", got)
+		}
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	m := &managerWU{
+		keys:    newAPIKeyPool([]string{"testkey"}),
+		client:  srv.Client(),
+		baseURL: srv.URL,
+		timeout: time.Second,
+	}
+	res, err := m.requestCurrent("KWAFRUIT1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Observations) != 1 || res.Observations[0].StationID != "KWAFRUIT1" {
+		t.Errorf("unexpected observations: %v", res.Observations)
+	}
+}
+
+// fakeWriteAPI records the points written to it.
+type fakeWriteAPI struct {
+	points []*write.Point
+	err    error
+}
+
+func (f *fakeWriteAPI) WriteRecord(ctx context.Context, line ...string) error {
+	return f.err
+}
+
+func (f *fakeWriteAPI) WritePoint(ctx context.Context, point ...*write.Point) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.points = append(f.points, point...)
+	return nil
+}
+
+func TestRecordObsTimestamp(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+
+	b, err := ioutil.ReadFile(filepath.Join("..", "example-kwafruit1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &weatherUndergroundObservations{}
+	if err := json.Unmarshal(b, data); err != nil {
+		t.Fatal(err)
+	}
+
+	api := &fakeWriteAPI{}
+	m := &managerInflux{
+		currentStation: "KWAFRUIT1",
+		clientAPI:      api,
+		namespace:      "wu2.",
+	}
+	obsTime := time.Unix(1634660430, 0)
+	polled := obsTime.Add(90 * time.Second)
+	if err := m.recordObs(data.Observations[0], polled); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(api.points) == 0 {
+		t.Fatal("no points written")
+	}
+	for _, pt := range api.points {
+		if !pt.Time().Equal(obsTime) {
+			t.Errorf("%s: want observation time %v, got %v", pt.Name(), obsTime, pt.Time())
+		}
+		if pt.Name() == "wu2.station.meta" {
+			if len(pt.TagList()) != 1 {
+				t.Errorf("want only stationID tag on metadata, got %v", pt.TagList())
+			}
+			continue
+		}
+		if len(pt.TagList()) != 3 {
+			t.Errorf("%s: want stationID, neighborhood and country tags, got %v", pt.Name(), pt.TagList())
+		}
+		if pt.Name() == "wu2.ingestLag.gauge" {
+			if v := pt.FieldList()[0].Value; v != 90.0 {
+				t.Errorf("ingestLag: got %v", v)
+			}
+		}
+	}
+}
+
+// resizeForecast stretches or truncates every array in the forecast to the given number of days,
+// as though the forecast had been requested for that horizon.
+func resizeForecast(t *testing.T, data []byte, days int) []byte {
+	forecast := map[string]interface{}{}
+	if err := json.Unmarshal(data, &forecast); err != nil {
+		t.Fatal(err)
+	}
+	resize := func(sl []interface{}, n int) []interface{} {
+		out := make([]interface{}, n)
+		for i := range out {
+			out[i] = sl[i%len(sl)]
+		}
+		return out
+	}
+	for k, v := range forecast {
+		if sl, ok := v.([]interface{}); ok && k != "daypart" {
+			forecast[k] = resize(sl, days)
+		}
+	}
+	daypart := forecast["daypart"].([]interface{})[0].(map[string]interface{})
+	for k, v := range daypart {
+		daypart[k] = resize(v.([]interface{}), days*2)
+	}
+	b, err := json.Marshal(forecast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRecordForecastHorizons(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("..", "forecast-example.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, days := range []int{3, 8, 16} {
+		api := &fakeWriteAPI{}
+		m := &managerInflux{clientAPI: api, namespace: "wu2."}
+		if err := m.recordForecast(resizeForecast(t, b, days), nil); err != nil {
+			t.Fatalf("days=%d: %v", days, err)
+		}
+		if len(api.points) != len(forecastFields) {
+			t.Fatalf("days=%d: want %d points, got %d", days, len(forecastFields), len(api.points))
+		}
+		for _, pt := range api.points {
+			want := days
+			if strings.Contains(pt.Name(), "daypart") {
+				want = days * 2
+			}
+			if got := len(pt.FieldList()); got != want {
+				t.Errorf("days=%d: %s: want %d fields, got %d", days, pt.Name(), want, got)
+			}
+		}
+	}
+}
+
+func TestRecordForecastHorizonsAtNight(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("..", "forecast-example.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, days := range []int{10, 15} {
+		// At night the API nulls the first daypart, which has passed.
+		forecast := map[string]interface{}{}
+		if err := json.Unmarshal(resizeForecast(t, b, days), &forecast); err != nil {
+			t.Fatal(err)
+		}
+		daypart := forecast["daypart"].([]interface{})[0].(map[string]interface{})
+		for _, v := range daypart {
+			v.([]interface{})[0] = nil
+		}
+		// Number the temperatures to follow the shift.
+		temps := daypart["temperature"].([]interface{})
+		for i := 1; i < len(temps); i++ {
+			temps[i] = float64(i)
+		}
+		data, err := json.Marshal(forecast)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		api := &fakeWriteAPI{}
+		m := &managerInflux{clientAPI: api, namespace: "wu2."}
+		if err := m.recordForecast(data, nil); err != nil {
+			t.Fatalf("days=%d: %v", days, err)
+		}
+		for _, pt := range api.points {
+			// The first value is dropped, and the rest shift down by one.
+			want := days - 1
+			if strings.Contains(pt.Name(), "daypart") {
+				want = days*2 - 1
+			}
+			fields := map[string]interface{}{}
+			for _, f := range pt.FieldList() {
+				fields[f.Key] = f.Value
+			}
+			if len(fields) != want {
+				t.Errorf("days=%d: %s: want %d fields, got %d", days, pt.Name(), want, len(fields))
+			}
+			if _, ok := fields[fmt.Sprintf("%d", want)]; ok {
+				t.Errorf("days=%d: %s: want fields 0..%d, got %d", days, pt.Name(), want-1, want)
+			}
+			if pt.Name() == "wu2./forecast/daypart.0.temperature" {
+				if fields["0"] != 1.0 || fields[fmt.Sprintf("%d", want-1)] != float64(want) {
+					t.Errorf("days=%d: want the night values first, got %v", days, fields)
+				}
+			}
+		}
+	}
+}
+
+func TestRunStopsBetweenStations(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+
+	b, err := ioutil.ReadFile(filepath.Join("..", "example-kwafruit1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			close(stop)
+		}
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	rc := &runConfig{
+		manWU:         &managerWU{client: srv.Client(), baseURL: srv.URL, timeout: time.Second},
+		managerInflux: &managerInflux{clientAPI: &fakeWriteAPI{}, namespace: "wu2."},
+		stations:      []string{"KWAFRUIT1", "KWAFRUIT2"},
+		interval:      time.Hour,
+		stop:          stop,
+	}
+	run(rc)
+
+	if requests != 1 {
+		t.Errorf("want the run to stop after the first station, got %d requests", requests)
+	}
+}
+
+func TestRunAddsForecastTargetForSkippedObservation(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+	flagAppForecastPerStation = true
+	defer func() { flagAppForecastPerStation = false }()
+
+	b, err := ioutil.ReadFile(filepath.Join("..", "example-kwafruit1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &weatherUndergroundObservations{}
+	if err := json.Unmarshal(b, data); err != nil {
+		t.Fatal(err)
+	}
+	// The observation was written before a restart.
+	saveStationState("KWAFRUIT1", &stationState{LastEpoch: data.Observations[0].Epoch})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	api := &fakeWriteAPI{}
+	rc := &runConfig{
+		manWU:            &managerWU{client: srv.Client(), baseURL: srv.URL, timeout: time.Second},
+		managerInflux:    &managerInflux{clientAPI: api, namespace: "wu2."},
+		stations:         []string{"KWAFRUIT1"},
+		stationForecasts: map[string]*forecastTarget{},
+	}
+	run(rc)
+
+	if rc.stationForecasts["KWAFRUIT1"] == nil || len(rc.forecastTargets) != 1 {
+		t.Errorf("want a forecast target for the station, got %v", rc.forecastTargets)
+	}
+	for _, pt := range api.points {
+		if strings.HasSuffix(pt.Name(), "epoch.gauge") {
+			t.Errorf("want the observation skipped, got %s", pt.Name())
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// airQualityPollutants are the pollutants recorded, keyed by their name in the API response.
+// The values are their field name prefixes.
+var airQualityPollutants = map[string]string{
+	"PM2.5": "pm25",
+	"PM10":  "pm10",
+	"O3":    "o3",
+	"NO2":   "no2",
+	"CO":    "co",
+	"SO2":   "so2",
+}
+
+// airQualityDefaultExpiry is used when the response has no expiry.
+const airQualityDefaultExpiry = time.Hour
+
+// globalAirQuality is the response of /v3/wx/globalAirQuality.
+type globalAirQuality struct {
+	GlobalAirQuality struct {
+		AirQualityIndex         *float64                     `json:"airQualityIndex"`
+		AirQualityCategory      *string                      `json:"airQualityCategory"`
+		AirQualityCategoryIndex *float64                     `json:"airQualityCategoryIndex"`
+		PrimaryPollutant        *string                      `json:"primaryPollutant"`
+		Source                  string                       `json:"source"`
+		ExpireTimeGmt           int64                        `json:"expireTimeGmt"`
+		Pollutants              map[string]*airQualityAmount `json:"pollutants"`
+	} `json:"globalairquality"`
+}
+
+// airQualityAmount is the concentration of a pollutant.
+type airQualityAmount struct {
+	Amount   *float64 `json:"amount"`
+	Unit     *string  `json:"unit"`
+	Index    *float64 `json:"index"`
+	Category *string  `json:"category"`
+}
+
+// fields returns the non-null values, with each pollutant's concentration, unit and index
+// under its field name prefix, eg. pm25, pm25Unit and pm25Index.
+func (a *globalAirQuality) fields() map[string]interface{} {
+	q := a.GlobalAirQuality
+	f := map[string]interface{}{}
+	addFloat(f, "aqi", q.AirQualityIndex)
+	addString(f, "category", q.AirQualityCategory)
+	addFloat(f, "categoryIndex", q.AirQualityCategoryIndex)
+	addString(f, "primaryPollutant", q.PrimaryPollutant)
+	for name, prefix := range airQualityPollutants {
+		p := q.Pollutants[name]
+		if p == nil {
+			continue
+		}
+		addFloat(f, prefix, p.Amount)
+		addString(f, prefix+"Unit", p.Unit)
+		addFloat(f, prefix+"Index", p.Index)
+	}
+	return f
+}
+
+// expiry returns when the air quality should next be requested.
+func (a *globalAirQuality) expiry(now time.Time) time.Time {
+	if t := time.Unix(a.GlobalAirQuality.ExpireTimeGmt, 0); a.GlobalAirQuality.ExpireTimeGmt > 0 && t.After(now) {
+		return t
+	}
+	return now.Add(airQualityDefaultExpiry)
+}
+
+// requestAirQuality requests the air quality at the location, which must be a geocode,
+// with the AQI on the EPA scale.
+func (m *managerWU) requestAirQuality(location forecastLocation) (res *globalAirQuality, err error) {
+	if location.key != "geocode" {
+		return nil, fmt.Errorf("air quality needs a geocode location, got %s", location)
+	}
+	payload := url.Values{}
+	payload.Add("geocode", location.value)
+	payload.Add("scale", "EPA")
+	payload.Add("format", "json")
+	payload.Add("language", "en-US")
+	dataBytes, err := m.get("/v3/wx/globalAirQuality", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the response body.
+	data := &globalAirQuality{}
+	err = json.Unmarshal(dataBytes, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// recordAirQuality writes the air quality as a single point,
+// tagged with its source and the given tags (if any).
+func (m *managerInflux) recordAirQuality(aq *globalAirQuality, extraTags map[string]string, now time.Time) error {
+	measurementName := fmt.Sprintf("%s/airquality", m.namespace)
+	tags := map[string]string{}
+	if aq.GlobalAirQuality.Source != "" {
+		tags["source"] = aq.GlobalAirQuality.Source
+	}
+	for k, v := range extraTags {
+		tags[k] = v
+	}
+	fields := aq.fields()
+	if len(fields) == 0 {
+		return fmt.Errorf("air quality has no values")
+	}
+
+	p := influxdb2.NewPoint(measurementName, tags, fields, now)
+	if err := m.clientAPI.WritePoint(context.Background(), p); err != nil {
+		log.Error("Write point", "error", err)
+		return err
+	}
+	log.Debug("Wrote point", "type", "airquality", measurementName, len(fields))
+	return nil
+}
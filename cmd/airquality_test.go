@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAirQuality(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/wx/globalAirQuality" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("geocode"); got != "48.029,-118.367" {
+			t.Errorf("geocode: got %q", got)
+		}
+		w.Write([]byte(`{"globalairquality":{"latitude":48.03,"longitude":-118.37,"source":"EPA AirNow",
+			"airQualityIndex":162,"airQualityCategory":"Unhealthy","airQualityCategoryIndex":4,"primaryPollutant":"PM2.5",
+			"expireTimeGmt":1637084600,
+			"pollutants":{
+				"PM2.5":{"name":"Particulate matter <2.5 microns","amount":76.4,"unit":"microgram per cubic meter","category":"Unhealthy","categoryIndex":4,"index":162},
+				"PM10":{"name":"Particulate matter <10 microns","amount":88.1,"unit":"microgram per cubic meter","category":"Moderate","categoryIndex":2,"index":67},
+				"O3":{"name":"Ozone","amount":null,"unit":"ppb","category":null,"categoryIndex":null,"index":null}
+			}}}`))
+	}))
+	defer srv.Close()
+
+	m := &managerWU{client: srv.Client(), baseURL: srv.URL, timeout: time.Second}
+	if _, err := m.requestAirQuality(forecastLocation{key: "postalKey", value: "99129:US"}); err == nil {
+		t.Error("want an error for a non-geocode location")
+	}
+	aq, err := m.requestAirQuality(forecastLocation{key: "geocode", value: "48.029,-118.367"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := aq.expiry(time.Unix(1637081000, 0)); !got.Equal(time.Unix(1637084600, 0)) {
+		t.Errorf("want the response's expiry, got %v", got)
+	}
+
+	api := &fakeWriteAPI{}
+	mi := &managerInflux{clientAPI: api, namespace: "wu2."}
+	if err := mi.recordAirQuality(aq, map[string]string{"locationKey": "geocode"}, time.Unix(1637081000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.points) != 1 {
+		t.Fatalf("want 1 point, got %d", len(api.points))
+	}
+	pt := api.points[0]
+	if pt.Name() != "wu2./airquality" {
+		t.Errorf("unexpected measurement: %s", pt.Name())
+	}
+	fields := map[string]interface{}{}
+	for _, f := range pt.FieldList() {
+		fields[f.Key] = f.Value
+	}
+	for k, want := range map[string]interface{}{
+		"aqi":       162.0,
+		"category":  "Unhealthy",
+		"pm25":      76.4,
+		"pm25Index": 162.0,
+		"pm10":      88.1,
+		"o3Unit":    "ppb",
+	} {
+		if fields[k] != want {
+			t.Errorf("%s: want %v, got %v", k, want, fields[k])
+		}
+	}
+	if _, ok := fields["o3"]; ok {
+		t.Error("want null amounts omitted")
+	}
+	tags := map[string]string{}
+	for _, tag := range pt.TagList() {
+		tags[tag.Key] = tag.Value
+	}
+	if tags["source"] != "EPA AirNow" || tags["locationKey"] != "geocode" {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// alertHeadlines is the response of /v3/alerts/headlines.
+type alertHeadlines struct {
+	Alerts []*alertHeadline `json:"alerts"`
+}
+
+// alertHeadline is a weather alert (eg. watch, warning or advisory) in effect at a location.
+type alertHeadline struct {
+	DetailKey        string `json:"detailKey"`
+	EventDescription string `json:"eventDescription"`
+	Severity         string `json:"severity"`
+	Significance     string `json:"significance"`
+	Phenomena        string `json:"phenomena"`
+	HeadlineText     string `json:"headlineText"`
+	AreaName         string `json:"areaName"`
+	OfficeName       string `json:"officeName"`
+	OnsetTimeLocal   string `json:"onsetTimeLocal"`
+	IssueTimeLocal   string `json:"issueTimeLocal"`
+	ExpireTimeUTC    int64  `json:"expireTimeUTC"`
+}
+
+// alertDetailResponse is the response of /v3/alerts/detail.
+type alertDetailResponse struct {
+	AlertDetail struct {
+		Texts []struct {
+			Description string `json:"description"`
+			Instruction string `json:"instruction"`
+		} `json:"texts"`
+	} `json:"alertDetail"`
+}
+
+// onset returns the time the alert takes effect, falling back to its issue time.
+func (a *alertHeadline) onset() time.Time {
+	for _, s := range []string{a.OnsetTimeLocal, a.IssueTimeLocal} {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (m *managerWU) requestAlertHeadlines(location forecastLocation) (res *alertHeadlines, err error) {
+	payload := url.Values{}
+	payload.Add(location.key, location.value)
+	payload.Add("format", "json")
+	payload.Add("language", "en-US")
+	dataBytes, err := m.get("/v3/alerts/headlines", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the response body.
+	// The API responds 204 No Content when there are no alerts.
+	data := &alertHeadlines{}
+	if len(dataBytes) == 0 {
+		return data, nil
+	}
+	err = json.Unmarshal(dataBytes, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *managerWU) requestAlertDetail(alertID string) (res *alertDetailResponse, err error) {
+	payload := url.Values{}
+	payload.Add("alertId", alertID)
+	payload.Add("format", "json")
+	payload.Add("language", "en-US")
+	dataBytes, err := m.get("/v3/alerts/detail", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the response body.
+	data := &alertDetailResponse{}
+	err = json.Unmarshal(dataBytes, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// recordAlert writes the alert as an event at its onset time,
+// tagged with its ID, event type and severity, and the given tags (if any).
+// The ID tag keeps alerts of the same type and onset (eg. for different zones) apart.
+// The description may be empty.
+func (m *managerInflux) recordAlert(alert *alertHeadline, description string, extraTags map[string]string) error {
+	measurementName := fmt.Sprintf("%s/alerts", m.namespace)
+	tags := map[string]string{
+		"alertID":   alert.DetailKey,
+		"eventType": alert.EventDescription,
+		"severity":  alert.Severity,
+	}
+	for k, v := range extraTags {
+		tags[k] = v
+	}
+	fields := map[string]interface{}{
+		"headline": alert.HeadlineText,
+		"areaName": alert.AreaName,
+		"expiry":   alert.ExpireTimeUTC,
+	}
+	if description != "" {
+		fields["description"] = description
+	}
+	onset := alert.onset()
+	if onset.IsZero() {
+		onset = time.Now()
+	}
+	fields["onset"] = onset.Unix()
+
+	p := influxdb2.NewPoint(measurementName, tags, fields, onset)
+	if err := m.clientAPI.WritePoint(context.Background(), p); err != nil {
+		log.Error("Write point", "error", err)
+		return err
+	}
+	log.Info("Wrote alert", "event", alert.EventDescription, "severity", alert.Severity, "headline", alert.HeadlineText)
+	return nil
+}
+
+// seenAlertKey identifies an alert recorded for a target.
+// An alert can cover several targets (eg. per-station forecasts), and is recorded for each,
+// with its tags.
+func seenAlertKey(alertID string, target *forecastTarget) string {
+	return alertID + "@" + target.location.String()
+}
+
+// runAlerts records any alerts at the target's location which have not been recorded for it yet.
+func (rc *runConfig) runAlerts(target *forecastTarget) {
+	res, err := rc.manWU.requestAlertHeadlines(target.location)
+	if err != nil {
+		log.Error("Alert headlines request failed", "location", target.location, "error", err)
+		return
+	}
+
+	seen := getSeenAlerts()
+	for _, alert := range res.Alerts {
+		if alert == nil || alert.DetailKey == "" {
+			continue
+		}
+		key := seenAlertKey(alert.DetailKey, target)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+
+		// The detail is nice to have, but the headline is the event.
+		var description string
+		detail, err := rc.manWU.requestAlertDetail(alert.DetailKey)
+		if err != nil {
+			log.Warn("Alert detail request failed", "alertID", alert.DetailKey, "error", err)
+		} else {
+			var texts []string
+			for _, text := range detail.AlertDetail.Texts {
+				texts = append(texts, text.Description)
+			}
+			description = strings.Join(texts, "\n")
+		}
+
+		if err := rc.managerInflux.recordAlert(alert, description, target.tags); err != nil {
+			log.Error("Post InfluxDB alert", "alertID", alert.DetailKey, "error", err)
+			continue
+		}
+		seen[key] = alert.ExpireTimeUTC
+	}
+	saveSeenAlerts(seen)
+}
+
+// getSeenAlerts returns the alerts which have been recorded, keyed by seenAlertKey, with their expiry epochs.
+// Expired alerts are forgotten.
+func getSeenAlerts() map[string]int64 {
+	seen := map[string]int64{}
+	os.MkdirAll(flagAppDatadir, os.ModePerm)
+	data, err := ioutil.ReadFile(filepath.Join(flagAppDatadir, "alerts"))
+	if err != nil {
+		return seen
+	}
+	err = json.Unmarshal(data, &seen)
+	if err != nil {
+		log.Warn("Ignoring unreadable seen alerts", "error", err)
+		return map[string]int64{}
+	}
+	now := time.Now().Unix()
+	for id, expiry := range seen {
+		if expiry != 0 && expiry < now {
+			delete(seen, id)
+		}
+	}
+	return seen
+}
+
+func saveSeenAlerts(seen map[string]int64) {
+	os.MkdirAll(flagAppDatadir, os.ModePerm)
+	data, err := json.Marshal(seen)
+	if err != nil {
+		log.Error("Failed to marshal JSON seen alerts", "error", err)
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(flagAppDatadir, "alerts"), data, os.ModePerm)
+	if err != nil {
+		log.Error("Failed to save seen alerts", "error", err)
+	}
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRunAlertsDeduplicates(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+
+	details := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/alerts/headlines":
+			w.Write([]byte(`{"alerts":[{"detailKey":"abc123","eventDescription":"Winter Storm Warning","severity":"Moderate","headlineText":"Winter Storm Warning until SAT 4:00 AM PST","onsetTimeLocal":"2021-11-16T10:00:00-08:00","expireTimeUTC":4102444800}]}`))
+		case "/v3/alerts/detail":
+			details++
+			w.Write([]byte(`{"alertDetail":{"texts":[{"description":"Heavy snow expected."}]}}`))
+		default:
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	api := &fakeWriteAPI{}
+	rc := &runConfig{
+		manWU:         &managerWU{client: srv.Client(), baseURL: srv.URL, timeout: time.Second},
+		managerInflux: &managerInflux{clientAPI: api, namespace: "wu2."},
+	}
+	target := newForecastTarget(forecastLocation{key: "geocode", value: "48.029,-118.367"})
+
+	rc.runAlerts(target)
+	rc.runAlerts(target)
+
+	if len(api.points) != 1 || details != 1 {
+		t.Fatalf("want the alert recorded once, got %d points and %d detail requests", len(api.points), details)
+	}
+	pt := api.points[0]
+	onset, _ := time.Parse(time.RFC3339, "2021-11-16T10:00:00-08:00")
+	if !pt.Time().Equal(onset) {
+		t.Errorf("want point at onset, got %v", pt.Time())
+	}
+	for _, f := range pt.FieldList() {
+		if f.Key == "description" && f.Value != "Heavy snow expected." {
+			t.Errorf("description: got %v", f.Value)
+		}
+	}
+}
+
+func TestRunAlertsPerTarget(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/alerts/headlines":
+			w.Write([]byte(`{"alerts":[{"detailKey":"abc123","eventDescription":"Winter Storm Warning","severity":"Moderate","expireTimeUTC":4102444800}]}`))
+		case "/v3/alerts/detail":
+			w.Write([]byte(`{"alertDetail":{"texts":[]}}`))
+		}
+	}))
+	defer srv.Close()
+
+	api := &fakeWriteAPI{}
+	rc := &runConfig{
+		manWU:         &managerWU{client: srv.Client(), baseURL: srv.URL, timeout: time.Second},
+		managerInflux: &managerInflux{clientAPI: api, namespace: "wu2."},
+	}
+	// The alert covers both stations.
+	targets := []*forecastTarget{
+		newForecastTarget(forecastLocation{key: "geocode", value: "48.029,-118.367"}),
+		newForecastTarget(forecastLocation{key: "geocode", value: "48.100,-118.400"}),
+	}
+	targets[0].tags["stationID"] = "KWAFRUIT1"
+	targets[1].tags["stationID"] = "KWAFRUIT2"
+	for i := 0; i < 2; i++ {
+		for _, target := range targets {
+			rc.runAlerts(target)
+		}
+	}
+
+	stations := map[string]int{}
+	for _, pt := range api.points {
+		for _, tag := range pt.TagList() {
+			if tag.Key == "stationID" {
+				stations[tag.Value]++
+			}
+		}
+	}
+	if len(api.points) != 2 || stations["KWAFRUIT1"] != 1 || stations["KWAFRUIT2"] != 1 {
+		t.Errorf("want the alert recorded once for each station, got %v", stations)
+	}
+}
+
+func TestRecordAlertsWithSameOnset(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+
+	// Warnings for two zones, alike but for their IDs.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/alerts/headlines":
+			w.Write([]byte(`{"alerts":[
+				{"detailKey":"abc123","eventDescription":"Flood Warning","severity":"Moderate","areaName":"Ferry","onsetTimeLocal":"2021-11-16T10:00:00-08:00","expireTimeUTC":4102444800},
+				{"detailKey":"def456","eventDescription":"Flood Warning","severity":"Moderate","areaName":"Stevens","onsetTimeLocal":"2021-11-16T10:00:00-08:00","expireTimeUTC":4102444800}
+			]}`))
+		case "/v3/alerts/detail":
+			w.Write([]byte(`{"alertDetail":{"texts":[]}}`))
+		}
+	}))
+	defer srv.Close()
+
+	api := &fakeWriteAPI{}
+	rc := &runConfig{
+		manWU:         &managerWU{client: srv.Client(), baseURL: srv.URL, timeout: time.Second},
+		managerInflux: &managerInflux{clientAPI: api, namespace: "wu2."},
+	}
+	rc.runAlerts(newForecastTarget(forecastLocation{key: "geocode", value: "48.029,-118.367"}))
+
+	// A point is identified by its measurement, tags and time.
+	keys := map[string]bool{}
+	for _, pt := range api.points {
+		key := pt.Name() + " " + pt.Time().String()
+		for _, tag := range pt.TagList() {
+			key += " " + tag.Key + "=" + tag.Value
+		}
+		keys[key] = true
+	}
+	if len(api.points) != 2 || len(keys) != 2 {
+		t.Errorf("want two distinct alert points, got %d points, %d distinct", len(api.points), len(keys))
+	}
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var errNoAPIKey = errors.New("no healthy API key")
+
+// How long a failed API key is left out of the rotation before it is tried again.
+const (
+	apiKeyAuthCooldown      = time.Hour
+	apiKeyRateLimitCooldown = time.Minute
+)
+
+// apiKey is a Weather Underground API key and its health.
+type apiKey struct {
+	key string
+	id  string
+
+	// failures counts consecutive auth and rate limit failures.
+	failures int
+
+	// failedClass is the error class of the last failure.
+	failedClass string
+
+	// disabledUntil is when a failed key is next tried.
+	disabledUntil time.Time
+}
+
+func (k *apiKey) healthy(now time.Time) bool {
+	return !now.Before(k.disabledUntil)
+}
+
+// apiKeyPool spreads API calls across the keys, round robin,
+// and fails over to the other keys while one is rejected (401/403) or rate limited (429).
+type apiKeyPool struct {
+	mu   sync.Mutex
+	keys []*apiKey
+	next int
+}
+
+func newAPIKeyPool(keys []string) *apiKeyPool {
+	p := &apiKeyPool{}
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		p.keys = append(p.keys, &apiKey{key: key, id: keyID(key)})
+	}
+	return p
+}
+
+// size returns the number of keys.
+func (p *apiKeyPool) size() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.keys)
+}
+
+// pick returns the next healthy key which has calls left in the budget (if any).
+// If no key is usable, it returns errQuotaExhausted if that is why,
+// or else a wuError of the class which disabled the keys,
+// with RetryAfter until the first key is tried again.
+// An empty pool returns an empty key.
+func (p *apiKeyPool) pick(now time.Time, budget *callBudget) (*apiKey, error) {
+	if p.size() == 0 {
+		return &apiKey{}, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var soonest *apiKey
+	for i := range p.keys {
+		k := p.keys[(p.next+i)%len(p.keys)]
+		if !k.healthy(now) {
+			if soonest == nil || k.disabledUntil.Before(soonest.disabledUntil) {
+				soonest = k
+			}
+			continue
+		}
+		if budget != nil && budget.perDay > 0 && budget.remaining(k.key) <= 0 {
+			// The count may be over the limit if the quota was lowered since it was saved.
+			continue
+		}
+		p.next = (p.next + i + 1) % len(p.keys)
+		return k, nil
+	}
+	if soonest == nil {
+		return nil, errQuotaExhausted
+	}
+	return nil, &wuError{Class: soonest.failedClass, RetryAfter: soonest.disabledUntil.Sub(now), Err: errNoAPIKey}
+}
+
+// available returns true if any key is healthy.
+func (p *apiKeyPool) available(now time.Time) bool {
+	if p.size() == 0 {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, k := range p.keys {
+		if k.healthy(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// report records the result of a call with the key.
+// Only auth and rate limit errors count against the key;
+// other errors are about the request, not the key.
+func (p *apiKeyPool) report(k *apiKey, err *wuError, now time.Time) {
+	if p.size() == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case err == nil:
+		k.failures = 0
+		k.failedClass = ""
+		k.disabledUntil = time.Time{}
+	case err.Class == errClassAuth:
+		k.failures++
+		k.failedClass = err.Class
+		k.disabledUntil = now.Add(apiKeyAuthCooldown)
+	case err.Class == errClassRateLimited:
+		k.failures++
+		k.failedClass = err.Class
+		cooldown := apiKeyRateLimitCooldown
+		if err.RetryAfter > cooldown {
+			cooldown = err.RetryAfter
+		}
+		k.disabledUntil = now.Add(cooldown)
+	}
+}
+
+// status returns a copy of each key's state, for metrics.
+func (p *apiKeyPool) status() []apiKey {
+	if p.size() == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make([]apiKey, len(p.keys))
+	for i, k := range p.keys {
+		status[i] = *k
+	}
+	return status
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyPoolRotation(t *testing.T) {
+	p := newAPIKeyPool([]string{"a", "b", "c"})
+	now := time.Now()
+	var got []string
+	for i := 0; i < 4; i++ {
+		k, err := p.pick(now, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, k.key)
+	}
+	if want := []string{"a", "b", "c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want round robin %v, got %v", want, got)
+	}
+
+	// b is rate limited, then c is revoked.
+	p.report(p.keys[1], &wuError{Class: errClassRateLimited, RetryAfter: 5 * time.Minute}, now)
+	p.report(p.keys[2], &wuError{Class: errClassAuth}, now)
+	for i := 0; i < 2; i++ {
+		if k, _ := p.pick(now, nil); k.key != "a" {
+			t.Errorf("want the healthy key, got %q", k.key)
+		}
+	}
+
+	p.report(p.keys[0], &wuError{Class: errClassAuth}, now)
+	_, err := p.pick(now, nil)
+	var wuErr *wuError
+	if !errors.As(err, &wuErr) || wuErr.Class != errClassRateLimited || wuErr.RetryAfter != 5*time.Minute {
+		t.Errorf("want rate limited until the first key is back, got %v", err)
+	}
+
+	// The rate limited key comes back first.
+	if k, err := p.pick(now.Add(5*time.Minute), nil); err != nil || k.key != "b" {
+		t.Errorf("want the key back after its cooldown, got %v %v", k, err)
+	}
+}
+
+func TestAPIKeyPoolSkipsOverspentKeys(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+
+	// The daily quota was lowered after a's calls were saved.
+	budget := newCallBudget(10, 0)
+	budget.day = quotaDay(time.Now())
+	budget.calls[keyID("a")] = 12
+
+	p := newAPIKeyPool([]string{"a", "b"})
+	for i := 0; i < 2; i++ {
+		k, err := p.pick(time.Now(), budget)
+		if err != nil || k.key != "b" {
+			t.Errorf("want the key with calls left, got %v %v", k, err)
+		}
+	}
+}
+
+func TestAPIKeyFailover(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("..", "example-kwafruit1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("apiKey") {
+		case "revoked":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write(b)
+		}
+	}))
+	defer srv.Close()
+
+	m := &managerWU{
+		keys:    newAPIKeyPool([]string{"revoked", "limited", "good"}),
+		client:  srv.Client(),
+		baseURL: srv.URL,
+		timeout: time.Second,
+	}
+	for i := 0; i < 3; i++ {
+		res, err := m.requestCurrent("KWAFRUIT1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Observations) != 1 {
+			t.Errorf("unexpected observations: %v", res.Observations)
+		}
+	}
+	for _, k := range m.keys.status() {
+		if healthy := k.healthy(time.Now()); healthy != (k.key == "good") {
+			t.Errorf("key %s: healthy=%v", k.key, healthy)
+		}
+	}
+}
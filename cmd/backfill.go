@@ -0,0 +1,311 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+	influxdb2_api "github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/spf13/cobra"
+)
+
+// backfillCmd represents the backfill command
+var backfillCmd = &cobra.Command{
+	Use:   "backfill",
+	Short: "Backfill observations from the PWS history endpoints",
+	Long: `Backfill requests the PWS history endpoints day by day for each of the
+configured stations (--wu_stations), and writes the observations to InfluxDB
+at their own timestamps.
+
+Observations from the 'all' (5 minute) product are also written to the same
+measurements as the ETL command writes, so gaps in those series get filled.
+
+Completed days are recorded in the datadir, so an interrupted backfill
+can be resumed by running it again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		setupLogging()
+
+		from, to, err := parseBackfillRange(flagBackfillFrom, flagBackfillTo)
+		if err != nil {
+			log.Crit("Invalid backfill range", "error", err)
+		}
+		for _, product := range flagBackfillProducts {
+			if !isHistoryProduct(product) {
+				log.Crit("Invalid backfill product", "product", product, "valid", historyProducts)
+			}
+		}
+
+		managerInflux := newManagerInflux()
+		bc := &backfillConfig{
+			manWU:         newManagerWU(),
+			managerInflux: managerInflux,
+			api:           managerInflux.client.WriteAPIBlocking(flagInfluxOrg, flagInfluxBucket),
+			stations:      flagWUStations,
+			products:      flagBackfillProducts,
+			from:          from,
+			to:            to,
+			delay:         flagBackfillDelay,
+			redo:          flagBackfillRedo,
+		}
+
+		bc.managerInflux.checkSchema(flagInfluxSchemaWindow)
+		bc.stop = stopOnSignal()
+		bc.manWU.stop = bc.stop
+		backfill(bc)
+		bc.managerInflux.close()
+	},
+}
+
+var flagBackfillFrom string
+var flagBackfillTo string
+var flagBackfillProducts []string
+var flagBackfillDelay time.Duration
+var flagBackfillRedo bool
+
+// historyProducts are the PWS history endpoints, /v2/pws/history/<product>.
+var historyProducts = []string{"all", "hourly", "daily"}
+
+const backfillDateLayout = "2006-01-02"
+
+func init() {
+	rootCmd.AddCommand(backfillCmd)
+
+	backfillCmd.PersistentFlags().StringVar(&flagBackfillFrom, "backfill_from", "", "First day to backfill (YYYY-MM-DD)")
+	backfillCmd.PersistentFlags().StringVar(&flagBackfillTo, "backfill_to", "", "Last day to backfill (YYYY-MM-DD). Default is today.")
+	backfillCmd.PersistentFlags().StringSliceVar(&flagBackfillProducts, "backfill_products", []string{"all"}, "History products to backfill [all,hourly,daily]")
+	backfillCmd.PersistentFlags().DurationVar(&flagBackfillDelay, "backfill_delay", time.Second, "Delay between API requests")
+	backfillCmd.PersistentFlags().BoolVar(&flagBackfillRedo, "backfill_redo", false, "Backfill days which have already been completed")
+}
+
+type backfillConfig struct {
+	manWU         *managerWU
+	managerInflux *managerInflux
+	api           influxdb2_api.WriteAPIBlocking // writes each backfilled day
+	stations      []string
+	products      []string
+	from, to      time.Time
+	delay         time.Duration
+	redo          bool
+	stop          <-chan struct{} // closed to end the backfill between days
+}
+
+func isHistoryProduct(product string) bool {
+	for _, p := range historyProducts {
+		if p == product {
+			return true
+		}
+	}
+	return false
+}
+
+func parseBackfillRange(fromStr, toStr string) (from, to time.Time, err error) {
+	if fromStr == "" {
+		return from, to, errors.New("backfill_from is required")
+	}
+	from, err = time.Parse(backfillDateLayout, fromStr)
+	if err != nil {
+		return from, to, err
+	}
+	to = time.Now().UTC().Truncate(24 * time.Hour)
+	if toStr != "" {
+		to, err = time.Parse(backfillDateLayout, toStr)
+		if err != nil {
+			return from, to, err
+		}
+	}
+	if to.Before(from) {
+		return from, to, fmt.Errorf("backfill_to (%s) is before backfill_from (%s)", toStr, fromStr)
+	}
+	return from, to, nil
+}
+
+func backfill(bc *backfillConfig) {
+	for _, station := range bc.stations {
+		bc.managerInflux.currentStation = station
+
+		for _, product := range bc.products {
+			progress := getBackfillProgress(station, product)
+			if bc.redo {
+				progress = &backfillProgress{Completed: map[string]bool{}}
+			}
+
+		daysLoop:
+			for day := bc.from; !day.After(bc.to); day = day.AddDate(0, 0, 1) {
+				if stopped(bc.stop) {
+					return
+				}
+				date := day.Format("20060102")
+				if progress.Completed[date] {
+					log.Debug("Skipping completed backfill day", "station", station, "product", product, "date", date)
+					continue
+				}
+
+				res, err := bc.manWU.requestHistory(station, product, day)
+				if err != nil {
+					log.Error("Backfill request failed", "station", station, "product", product, "date", date, "error", err)
+					break daysLoop
+				}
+				points := &pointBuffer{}
+				bc.managerInflux.clientAPI = points
+				for _, obs := range res.Observations {
+					if err := bc.managerInflux.recordHistoryObs(product, obs); err != nil {
+						log.Error("Post InfluxDB history observation", "error", err)
+						break daysLoop
+					}
+				}
+				if err := points.flush(context.Background(), bc.api); err != nil {
+					log.Error("Backfill write failed", "station", station, "product", product, "date", date, "error", err)
+					bc.managerInflux.writeErrors.handle(err)
+					break daysLoop
+				}
+				log.Info("Backfilled day", "station", station, "product", product, "date", date, "observations", len(res.Observations))
+
+				// The history date is the station's local date, so allow for its time zone
+				// before calling the day complete.
+				if day.AddDate(0, 0, 2).Before(time.Now()) {
+					progress.Completed[date] = true
+					saveBackfillProgress(station, product, progress)
+				}
+
+				if !sleepUntilStopped(bc.stop, bc.delay) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// pointBuffer collects the points of a backfilled day,
+// to write them with a single blocking request.
+type pointBuffer struct {
+	lines  []string
+	points []*write.Point
+}
+
+func (b *pointBuffer) WriteRecord(ctx context.Context, line ...string) error {
+	b.lines = append(b.lines, line...)
+	return nil
+}
+
+func (b *pointBuffer) WritePoint(ctx context.Context, point ...*write.Point) error {
+	b.points = append(b.points, point...)
+	return nil
+}
+
+// flush writes the collected lines and points, and returns the first error.
+func (b *pointBuffer) flush(ctx context.Context, api influxdb2_api.WriteAPIBlocking) error {
+	if len(b.lines) > 0 {
+		if err := api.WriteRecord(ctx, b.lines...); err != nil {
+			return err
+		}
+	}
+	if len(b.points) > 0 {
+		if err := api.WritePoint(ctx, b.points...); err != nil {
+			return err
+		}
+	}
+	b.lines, b.points = nil, nil
+	return nil
+}
+
+// backfillProgress records the days which have been backfilled, keyed by YYYYMMDD.
+type backfillProgress struct {
+	Completed map[string]bool `json:"completed"`
+}
+
+func backfillProgressPath(station, product string) string {
+	return filepath.Join(flagAppDatadir, "backfill-"+station+"-"+product)
+}
+
+func getBackfillProgress(station, product string) *backfillProgress {
+	v := &backfillProgress{Completed: map[string]bool{}}
+	os.MkdirAll(flagAppDatadir, os.ModePerm)
+	data, err := ioutil.ReadFile(backfillProgressPath(station, product))
+	if err != nil {
+		return v
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil || v.Completed == nil {
+		log.Warn("Ignoring unreadable backfill progress", "station", station, "product", product, "error", err)
+		return &backfillProgress{Completed: map[string]bool{}}
+	}
+	return v
+}
+
+func saveBackfillProgress(station, product string, progress *backfillProgress) {
+	os.MkdirAll(flagAppDatadir, os.ModePerm)
+	data, err := json.Marshal(progress)
+	if err != nil {
+		log.Error("Failed to marshal JSON backfill progress", "error", err)
+		return
+	}
+	err = ioutil.WriteFile(backfillProgressPath(station, product), data, os.ModePerm)
+	if err != nil {
+		log.Error("Failed to save backfill progress", "error", err)
+	}
+}
+
+// requestHistory requests a single day of the given history product for the station.
+func (m *managerWU) requestHistory(station, product string, day time.Time) (res *pwsHistoryObservations, err error) {
+	payload := url.Values{}
+	payload.Add("stationId", station)
+	payload.Add("format", "json")
+	payload.Add("units", "m")
+	payload.Add("date", day.Format("20060102"))
+	dataBytes, err := m.get("/v2/pws/history/"+product, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the response body.
+	// The API responds 204 No Content when the station has no data for the day.
+	data := &pwsHistoryObservations{}
+	if len(dataBytes) == 0 {
+		return data, nil
+	}
+	err = json.Unmarshal(dataBytes, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// recordHistoryObs writes the history observation at its own timestamp.
+// The summary values are written under the history.<product> annotation.
+// They are tagged like the station's live observations, if it has been polled.
+// Observations from the 'all' product are also written as current-conditions
+// observations (in each configured schema), since they share their granularity.
+func (m *managerInflux) recordHistoryObs(product string, obs *pwsHistoryObservation) error {
+	if obs == nil {
+		return errors.New("nil observation")
+	}
+	obsTime := obs.ObsTimeUtc
+
+	// History observations have no neighborhood or country,
+	// so use the tags of the station's live observations, to write to the same series.
+	tags := getStationState(m.currentStation).Tags
+
+	if product == "all" {
+		current := obs.observation()
+		blocks := map[string]map[string]interface{}{}
+		for annotation, units := range current.unitBlocks() {
+			blocks[annotation] = units.gauges()
+		}
+		m.postObservation(current.gauges(), blocks, tags, obsTime)
+	}
+
+	annotation := "history." + product
+	m.postGauges(annotation, obs.gauges(), tags, obsTime)
+	for unitsAnnotation, units := range obs.unitBlocks() {
+		m.postGauges(annotation+"."+unitsAnnotation, units.gauges(), tags, obsTime)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseBackfillRange(t *testing.T) {
+	from, to, err := parseBackfillRange("2021-11-10", "2021-11-16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if days := int(to.Sub(from).Hours() / 24); days != 6 {
+		t.Errorf("want 6 days between, got %d", days)
+	}
+
+	if _, _, err := parseBackfillRange("", ""); err == nil {
+		t.Error("want error for missing from")
+	}
+	if _, _, err := parseBackfillRange("2021-11-16", "2021-11-10"); err == nil {
+		t.Error("want error for to before from")
+	}
+}
+
+func TestBackfillProgressResume(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+
+	p := getBackfillProgress("KWAFRUIT1", "all")
+	if len(p.Completed) != 0 {
+		t.Fatalf("want empty progress, got %v", p.Completed)
+	}
+	p.Completed[time.Date(2021, 11, 10, 0, 0, 0, 0, time.UTC).Format("20060102")] = true
+	saveBackfillProgress("KWAFRUIT1", "all", p)
+
+	if !getBackfillProgress("KWAFRUIT1", "all").Completed["20211110"] {
+		t.Error("want completed day to be resumed")
+	}
+	if getBackfillProgress("KWAFRUIT1", "hourly").Completed["20211110"] {
+		t.Error("want progress to be per-product")
+	}
+}
+
+func TestRecordHistoryObsJoinsLiveSeries(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+	live := map[string]string{"neighborhood": "Fruitland", "country": "US"}
+	saveStationState("KWAFRUIT1", &stationState{LastEpoch: 1634660430, Tags: live})
+
+	temp := 10.5
+	obs := &pwsHistoryObservation{
+		StationID:  "KWAFRUIT1",
+		ObsTimeUtc: time.Unix(1634660130, 0),
+		Epoch:      1634660130,
+		Metric:     &pwsHistoryUnits{TempAvg: &temp},
+	}
+	api := &fakeWriteAPI{}
+	m := &managerInflux{currentStation: "KWAFRUIT1", clientAPI: api, namespace: "wu2."}
+	if err := m.recordHistoryObs("all", obs); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.points) == 0 {
+		t.Fatal("no points written")
+	}
+	for _, pt := range api.points {
+		tags := map[string]string{}
+		for _, tag := range pt.TagList() {
+			tags[tag.Key] = tag.Value
+		}
+		if tags["neighborhood"] != "Fruitland" || tags["country"] != "US" || tags["stationID"] != "KWAFRUIT1" {
+			t.Errorf("%s: want the live observation's tags, got %v", pt.Name(), tags)
+		}
+	}
+}
+
+func TestBackfillCompletesWrittenDays(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"observations":[{"stationID":"KWAFRUIT1","obsTimeUtc":"2021-11-10T12:00:00Z","epoch":1636545600,"metric":{"tempAvg":10.5}}]}`))
+	}))
+	defer srv.Close()
+
+	day := time.Date(2021, 11, 10, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		err      error
+		complete bool
+	}{
+		{errors.New("503 Service Unavailable"), false},
+		{nil, true},
+	} {
+		api := &fakeWriteAPI{err: tc.err}
+		writeErrors := &writeErrors{}
+		bc := &backfillConfig{
+			manWU:         &managerWU{client: srv.Client(), baseURL: srv.URL, timeout: time.Second},
+			managerInflux: &managerInflux{namespace: "wu2.", writeErrors: writeErrors},
+			api:           api,
+			stations:      []string{"KWAFRUIT1"},
+			products:      []string{"all"},
+			from:          day,
+			to:            day,
+		}
+		backfill(bc)
+
+		if got := getBackfillProgress("KWAFRUIT1", "all").Completed["20211110"]; got != tc.complete {
+			t.Errorf("write error %v: want complete %v, got %v", tc.err, tc.complete, got)
+		}
+		if tc.err != nil && writeErrors.total() != 1 {
+			t.Errorf("want the write error counted, got %d", writeErrors.total())
+		}
+		if tc.err == nil && len(api.points) == 0 {
+			t.Error("want the day's points written")
+		}
+	}
+}
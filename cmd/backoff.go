@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoffJitter is the fraction by which backoff delays are randomly varied,
+// so stations which fail together don't retry together.
+const backoffJitter = 0.2
+
+// stationBackoff is the backoff state of a station.
+// A station is either healthy (no failures) or backing off until its next attempt,
+// with the delay doubling for each consecutive failure, up to a maximum.
+type stationBackoff struct {
+	failures int
+	next     time.Time
+	delay    time.Duration
+}
+
+// ready returns true if the station may be polled.
+func (b *stationBackoff) ready(now time.Time) bool {
+	return b.failures == 0 || !now.Before(b.next)
+}
+
+// fail records a failed poll and returns the delay until the next attempt.
+// The delay starts at base, doubles for each consecutive failure up to max,
+// and is varied by the jitter. A longer retryAfter (eg. from the API) is honored.
+func (b *stationBackoff) fail(now time.Time, base, max, retryAfter time.Duration) time.Duration {
+	b.failures++
+	delay := base
+	for i := 1; i < b.failures && delay < max; i++ {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	delay += time.Duration((rand.Float64()*2 - 1) * backoffJitter * float64(delay))
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	b.delay = delay
+	b.next = now.Add(delay)
+	return delay
+}
+
+// succeed records a successful poll, and returns the number of failures it recovered from.
+func (b *stationBackoff) succeed() int {
+	failures := b.failures
+	*b = stationBackoff{}
+	return failures
+}
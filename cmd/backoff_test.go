@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStationBackoff(t *testing.T) {
+	now := time.Now()
+	b := &stationBackoff{}
+	if !b.ready(now) {
+		t.Fatal("want healthy station ready")
+	}
+
+	var delays []time.Duration
+	for i := 0; i < 6; i++ {
+		delays = append(delays, b.fail(now, time.Minute, 10*time.Minute, 0))
+	}
+	within := func(d, want time.Duration) bool {
+		return d >= want-time.Duration(backoffJitter*float64(want)) && d <= want+time.Duration(backoffJitter*float64(want))
+	}
+	for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute} {
+		if !within(delays[i], want) {
+			t.Errorf("failure %d: want about %v, got %v", i+1, want, delays[i])
+		}
+	}
+	if b.ready(now) || !b.ready(b.next) {
+		t.Error("want station ready only at its next attempt")
+	}
+	if got := b.fail(now, time.Minute, 10*time.Minute, time.Hour); got != time.Hour {
+		t.Errorf("want retryAfter honored, got %v", got)
+	}
+	if failures := b.succeed(); failures != 7 || !b.ready(now) {
+		t.Errorf("want reset after success, failures=%d", failures)
+	}
+}
+
+func TestRunFailingStationDoesNotBlockOthers(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+
+	b, err := ioutil.ReadFile(filepath.Join("..", "example-kwafruit1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("stationId") {
+		case "KBROKEN1":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		case "KGARBLED1":
+			w.Write([]byte("{not json"))
+			return
+		case "KNONET1":
+			// Drop the connection, as if the network were down.
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	api := &fakeWriteAPI{}
+	rc := &runConfig{
+		manWU: &managerWU{
+			client:        srv.Client(),
+			baseURL:       srv.URL,
+			timeout:       time.Second,
+			retryPolicies: map[string]retryPolicy{errClassServer: {tries: 1}, errClassNetwork: {tries: 1}},
+		},
+		managerInflux: &managerInflux{clientAPI: api, namespace: "wu2."},
+		stations:      []string{"KBROKEN1", "KGARBLED1", "KNONET1", "KWAFRUIT1"},
+		backoffMax:    time.Hour,
+	}
+	run(rc)
+
+	stations := map[string]bool{}
+	for _, pt := range api.points {
+		for _, tag := range pt.TagList() {
+			if tag.Key == "stationID" {
+				stations[tag.Value] = true
+			}
+		}
+	}
+	if !stations["KWAFRUIT1"] {
+		t.Error("want the healthy station polled after the failing one")
+	}
+	for _, station := range []string{"KBROKEN1", "KGARBLED1"} {
+		if rc.backoff(station).failures != 1 {
+			t.Errorf("want %s backing off, got %+v", station, rc.backoff(station))
+		}
+	}
+	if rc.backoff("KNONET1").failures != 0 {
+		t.Errorf("want a network error not to back off the station, got %+v", rc.backoff("KNONET1"))
+	}
+}
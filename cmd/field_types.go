@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+)
+
+// Policies for fields whose type in InfluxDB differs from the field registry's.
+// InfluxDB won't change the type of a field, so a value of the registry's type can't be written to it.
+const (
+	// typeConflictsRefuse refuses to start, with a report of the conflicts.
+	typeConflictsRefuse = "refuse"
+
+	// typeConflictsSuffix writes the value to a field suffixed with its type instead, eg. value_float.
+	typeConflictsSuffix = "suffix"
+)
+
+var typeConflictsPolicies = []string{typeConflictsRefuse, typeConflictsSuffix}
+
+func isTypeConflictsPolicy(policy string) bool {
+	for _, p := range typeConflictsPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// influxFieldTypes are the InfluxDB types of the registry's field types.
+// Times are written as float unix seconds.
+var influxFieldTypes = map[string]string{
+	fieldFloat:  "float",
+	fieldInt:    "integer",
+	fieldString: "string",
+	fieldBool:   "boolean",
+	fieldTime:   "float",
+}
+
+// influxType returns the InfluxDB type of the value, as SHOW FIELD KEYS names it.
+func influxType(v interface{}) string {
+	switch v.(type) {
+	case float64, float32:
+		return "float"
+	case int64, int, int32:
+		return "integer"
+	case uint64:
+		return "unsigned"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// fieldTypeConflict is a field whose type in InfluxDB differs from the type written to it.
+type fieldTypeConflict struct {
+	measurement string
+	field       string
+	existing    string
+	declared    string
+}
+
+func (c fieldTypeConflict) String() string {
+	return fmt.Sprintf("%s %s: %s in InfluxDB, %s in the registry", c.measurement, c.field, c.existing, c.declared)
+}
+
+// typeConflicts are the fields whose type in InfluxDB is known to differ from the type written,
+// found at startup or from write errors.
+type typeConflicts struct {
+	mu sync.Mutex
+
+	// existing are the types in InfluxDB, keyed by measurement and field.
+	existing map[string]map[string]string
+}
+
+func (c *typeConflicts) add(measurement, field, existing string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.existing == nil {
+		c.existing = map[string]map[string]string{}
+	}
+	if c.existing[measurement] == nil {
+		c.existing[measurement] = map[string]string{}
+	}
+	c.existing[measurement][field] = existing
+}
+
+// existingType returns the field's type in InfluxDB, if it is a known conflict.
+func (c *typeConflicts) existingType(measurement, field string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.existing[measurement][field]
+	return t, ok
+}
+
+// typeConflictError matches a write error for a field of another type,
+// capturing the field, measurement, and its type in InfluxDB.
+var typeConflictError = regexp.MustCompile(`field type conflict: input field "([^"]*)" on measurement "([^"]+)" is type \w+, already exists as type (\w+)`)
+
+// addFromError adds the conflict reported by a write error, if it is one.
+// It returns true if so.
+func (c *typeConflicts) addFromError(err error) bool {
+	if c == nil {
+		return false
+	}
+	m := typeConflictError.FindStringSubmatch(err.Error())
+	if m == nil {
+		return false
+	}
+	if t, ok := c.existingType(m[2], m[1]); !ok || t != m[3] {
+		log.Warn("Field type conflict", "measurement", m[2], "field", m[1], "existing", m[3])
+	}
+	c.add(m[2], m[1], m[3])
+	return true
+}
+
+// fieldKey returns the field to write the value to.
+// With the suffix policy, a value which conflicts with its field's type in InfluxDB
+// is written to the field suffixed with the value's type, eg. value_float.
+// Otherwise the field is written as it is (and the conflict is reported by InfluxDB).
+func (m *managerInflux) fieldKey(measurement, field string, value interface{}) string {
+	if m.conflictPolicy != typeConflictsSuffix {
+		return field
+	}
+	existing, ok := m.conflicts.existingType(measurement, field)
+	if !ok || existing == influxType(value) {
+		return field
+	}
+	return field + "_" + influxType(value)
+}
+
+// declaredFieldTypes returns the InfluxDB types the registry's fields are written as,
+// keyed by measurement and field, in each of the configured schemas.
+func (m *managerInflux) declaredFieldTypes(r *fieldRegistry) map[string]map[string]string {
+	declared := map[string]map[string]string{}
+	add := func(measurement, field, typ string) {
+		if declared[measurement] == nil {
+			declared[measurement] = map[string]string{}
+		}
+		declared[measurement][field] = influxFieldTypes[typ]
+	}
+	for path, spec := range r.specs {
+		if spec.drop {
+			continue
+		}
+		if m.writesSchema(schemaV2) {
+			add(m.namespace+path+".gauge", "value", spec.typ)
+		}
+		// Only current observations are written in schema v3, with the units as a tag.
+		if m.writesSchema(schemaV3) && !strings.HasPrefix(path, "history.") {
+			parts := strings.Split(path, ".")
+			add(schemaV3Measurement, parts[len(parts)-1], spec.typ)
+		}
+	}
+	return declared
+}
+
+// existingFieldTypes returns the types of the fields in InfluxDB, keyed by measurement and field.
+// On v1 they are read with SHOW FIELD KEYS, which lists a row per type if the shards disagree.
+// On v2 the schema package doesn't give field types,
+// so they are read from the types of the fields' last values within the window.
+func (m *managerInflux) existingFieldTypes(window time.Duration) (map[string]map[string][]string, error) {
+	existing := map[string]map[string][]string{}
+	add := func(measurement, field, typ string) {
+		if existing[measurement] == nil {
+			existing[measurement] = map[string][]string{}
+		}
+		for _, t := range existing[measurement][field] {
+			if t == typ {
+				return
+			}
+		}
+		existing[measurement][field] = append(existing[measurement][field], typ)
+	}
+
+	if m.isV1() {
+		series, err := m.queryInfluxQL("SHOW FIELD KEYS")
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range series {
+			for _, v := range s.Values {
+				if len(v) < 2 {
+					continue
+				}
+				field, _ := v[0].(string)
+				typ, _ := v[1].(string)
+				add(s.Name, field, typ)
+			}
+		}
+		return existing, nil
+	}
+
+	flux := fmt.Sprintf(`from(bucket: %q)
+  |> range(start: -%ds)
+  |> filter(fn: (r) => r._measurement =~ /^%s/ or r._measurement == %q)
+  |> last()`, m.bucket, int64(window.Seconds()), regexp.QuoteMeta(m.namespace), schemaV3Measurement)
+	result, err := m.client.QueryAPI(m.org).Query(context.Background(), flux)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+	for result.Next() {
+		r := result.Record()
+		add(r.Measurement(), r.Field(), influxType(r.Value()))
+	}
+	return existing, result.Err()
+}
+
+// checkFieldTypes compares the types of the fields in InfluxDB with the registry's,
+// and returns the conflicts, sorted. The conflicts are remembered, for fieldKey.
+func (m *managerInflux) checkFieldTypes(window time.Duration) ([]fieldTypeConflict, error) {
+	r := m.fields
+	if r == nil {
+		r = defaultFieldRegistry
+	}
+	existing, err := m.existingFieldTypes(window)
+	if err != nil {
+		return nil, err
+	}
+	var conflicts []fieldTypeConflict
+	for measurement, fields := range m.declaredFieldTypes(r) {
+		for field, declared := range fields {
+			for _, t := range existing[measurement][field] {
+				if t != declared {
+					conflicts = append(conflicts, fieldTypeConflict{measurement: measurement, field: field, existing: t, declared: declared})
+					m.conflicts.add(measurement, field, t)
+				}
+			}
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].String() < conflicts[j].String()
+	})
+	return conflicts, nil
+}
+
+// checkSchema checks the field types in InfluxDB at startup, and applies the type conflicts policy:
+// with refuse, any conflict is fatal.
+// If InfluxDB can't be queried, the conflicts are left to be found from write errors.
+func (m *managerInflux) checkSchema(window time.Duration) {
+	conflicts, err := m.checkFieldTypes(window)
+	if err != nil {
+		log.Error("Failed to read field types from InfluxDB; type conflicts will be found as they are written", "error", err)
+		return
+	}
+	if len(conflicts) == 0 {
+		log.Debug("Field types match the registry")
+		return
+	}
+	report := make([]string, len(conflicts))
+	for i, c := range conflicts {
+		report[i] = c.String()
+	}
+	if m.conflictPolicy == typeConflictsRefuse {
+		log.Crit("Field types in InfluxDB conflict with the registry; repair them with migrate fix-types, or use influx_type_conflicts=suffix",
+			"conflicts", len(conflicts), "report", "\n"+strings.Join(report, "\n"))
+	}
+	log.Warn("Field types in InfluxDB conflict with the registry; writing those values to suffixed fields",
+		"conflicts", len(conflicts), "report", "\n"+strings.Join(report, "\n"))
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckFieldTypesV1(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if q := r.FormValue("q"); q != "SHOW FIELD KEYS" {
+			t.Errorf("unexpected query: %s", q)
+		}
+		w.Write([]byte(`{"results":[{"statement_id":0,"series":[
+			{"name":"wu2.humidity.gauge","columns":["fieldKey","fieldType"],"values":[["value","float"]]},
+			{"name":"wu2.metric.windChill.gauge","columns":["fieldKey","fieldType"],"values":[["value","string"]]},
+			{"name":"wu2.metric.dewpt.gauge","columns":["fieldKey","fieldType"],"values":[["value","string"],["value","float"]]},
+			{"name":"wu2.metric.temp.gauge","columns":["fieldKey","fieldType"],"values":[["value","string"],["value_float","float"]]},
+			{"name":"observation","columns":["fieldKey","fieldType"],"values":[["epoch","integer"],["temp","float"]]},
+			{"name":"someone.else","columns":["fieldKey","fieldType"],"values":[["value","string"]]}
+		]}]}`))
+	}))
+	defer srv.Close()
+
+	m := &managerInflux{
+		endpoint:       srv.URL,
+		token:          "eve:secret",
+		bucket:         "dbriverrun/autogen",
+		namespace:      "wu2.",
+		schemas:        []string{schemaV2, schemaV3},
+		conflicts:      &typeConflicts{},
+		conflictPolicy: typeConflictsSuffix,
+	}
+	conflicts, err := m.checkFieldTypes(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"observation epoch: integer in InfluxDB, float in the registry",
+		"wu2.metric.dewpt.gauge value: string in InfluxDB, float in the registry",
+		"wu2.metric.temp.gauge value: string in InfluxDB, float in the registry",
+		"wu2.metric.windChill.gauge value: string in InfluxDB, float in the registry",
+	}
+	if len(conflicts) != len(want) {
+		t.Fatalf("want %d conflicts, got %v", len(want), conflicts)
+	}
+	for i, c := range conflicts {
+		if c.String() != want[i] {
+			t.Errorf("want %q, got %q", want[i], c)
+		}
+	}
+
+	if key := m.fieldKey("wu2.metric.temp.gauge", "value", 2.5); key != "value_float" {
+		t.Errorf("want value_float, got %s", key)
+	}
+	if key := m.fieldKey("wu2.metric.dewpt.gauge", "value", 2.5); key != "value_float" {
+		t.Errorf("want value_float for a field with mixed types, got %s", key)
+	}
+	if key := m.fieldKey("wu2.humidity.gauge", "value", 50.0); key != "value" {
+		t.Errorf("want value, got %s", key)
+	}
+}
@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+)
+
+// Types of observation fields, as written to InfluxDB.
+const (
+	fieldFloat  = "float"
+	fieldInt    = "int"
+	fieldString = "string"
+	fieldBool   = "bool"
+
+	// fieldTime is a time, written as float unix seconds like the epoch always has been.
+	fieldTime = "time"
+)
+
+// Policies for fields which are not in the registry.
+const (
+	unknownFieldsWrite = "write" // Write the value as it is.
+	unknownFieldsDrop  = "drop"  // Don't write it.
+)
+
+var unknownFieldsPolicies = []string{unknownFieldsWrite, unknownFieldsDrop}
+
+// fieldSpec declares an observation field.
+type fieldSpec struct {
+	typ  string
+	unit string
+
+	// nullable fields may be missing (null) from an observation.
+	nullable bool
+
+	// drop fields are not written.
+	drop bool
+}
+
+// fieldRegistry declares the observation fields, keyed by their exact path:
+// the annotation of their gauge measurement, eg. metric.temp or history.all.metric.tempAvg.
+// Each value is checked against its declared type before it is written.
+type fieldRegistry struct {
+	specs   map[string]fieldSpec
+	unknown string
+
+	mu     sync.Mutex
+	warned map[string]bool
+}
+
+// unitSystems are the units of the values in each unit block, by quantity.
+var unitSystems = map[string]map[string]string{
+	"metric":    {"temp": "C", "speed": "km/h", "pressure": "hPa", "pressureTrend": "hPa/h", "precipRate": "mm/h", "precip": "mm", "elev": "m"},
+	"imperial":  {"temp": "F", "speed": "mph", "pressure": "inHg", "pressureTrend": "inHg/h", "precipRate": "in/h", "precip": "in", "elev": "ft"},
+	"uk_hybrid": {"temp": "C", "speed": "mph", "pressure": "hPa", "pressureTrend": "hPa/h", "precipRate": "mm/h", "precip": "mm", "elev": "ft"},
+}
+
+// observationFields are the fields of current observations which don't depend on units.
+var observationFields = map[string]fieldSpec{
+	"epoch":          {typ: fieldTime, unit: "s"},
+	"solarRadiation": {typ: fieldFloat, unit: "W/m2", nullable: true},
+	"uv":             {typ: fieldFloat, nullable: true},
+	"winddir":        {typ: fieldFloat, unit: "deg", nullable: true},
+	"humidity":       {typ: fieldFloat, unit: "%", nullable: true},
+	"qcStatus":       {typ: fieldFloat, nullable: true},
+	"pollEpoch":      {typ: fieldTime, unit: "s"},
+	"ingestLag":      {typ: fieldFloat, unit: "s"},
+}
+
+// observationUnitsFields are the fields of current observations' unit blocks, by quantity.
+var observationUnitsFields = map[string]string{
+	"temp":             "temp",
+	"heatIndex":        "temp",
+	"dewpt":            "temp",
+	"windChill":        "temp",
+	"windSpeed":        "speed",
+	"windGust":         "speed",
+	"pressure":         "pressure",
+	"precipRate":       "precipRate",
+	"precipTotal":      "precip",
+	"precipCumulative": "precip",
+	"elev":             "elev",
+}
+
+// historyFields are the fields of history observations which don't depend on units.
+var historyFields = map[string]fieldSpec{
+	"epoch":              {typ: fieldTime, unit: "s"},
+	"solarRadiationHigh": {typ: fieldFloat, unit: "W/m2", nullable: true},
+	"uvHigh":             {typ: fieldFloat, nullable: true},
+	"winddirAvg":         {typ: fieldFloat, unit: "deg", nullable: true},
+	"humidityHigh":       {typ: fieldFloat, unit: "%", nullable: true},
+	"humidityLow":        {typ: fieldFloat, unit: "%", nullable: true},
+	"humidityAvg":        {typ: fieldFloat, unit: "%", nullable: true},
+	"qcStatus":           {typ: fieldFloat, nullable: true},
+}
+
+// historyUnitsFields are the fields of history observations' unit blocks, by quantity.
+var historyUnitsFields = map[string]string{
+	"tempHigh":      "temp",
+	"tempLow":       "temp",
+	"tempAvg":       "temp",
+	"windspeedHigh": "speed",
+	"windspeedLow":  "speed",
+	"windspeedAvg":  "speed",
+	"windgustHigh":  "speed",
+	"windgustLow":   "speed",
+	"windgustAvg":   "speed",
+	"dewptHigh":     "temp",
+	"dewptLow":      "temp",
+	"dewptAvg":      "temp",
+	"windchillHigh": "temp",
+	"windchillLow":  "temp",
+	"windchillAvg":  "temp",
+	"heatindexHigh": "temp",
+	"heatindexLow":  "temp",
+	"heatindexAvg":  "temp",
+	"pressureMax":   "pressure",
+	"pressureMin":   "pressure",
+	"pressureTrend": "pressureTrend",
+	"precipRate":    "precipRate",
+	"precipTotal":   "precip",
+}
+
+// newFieldRegistry returns the registry of the observation fields,
+// with the given fields dropped, and the policy for unknown fields.
+func newFieldRegistry(drop []string, unknown string) (*fieldRegistry, error) {
+	if !isUnknownFieldsPolicy(unknown) {
+		return nil, fmt.Errorf("invalid unknown fields policy %q, want %v", unknown, unknownFieldsPolicies)
+	}
+	r := &fieldRegistry{specs: map[string]fieldSpec{}, unknown: unknown, warned: map[string]bool{}}
+
+	// Unit-dependent values may be null, eg. a station without a rain gauge.
+	addUnits := func(prefix string, fields map[string]string) {
+		for system, units := range unitSystems {
+			for field, quantity := range fields {
+				r.specs[prefix+system+"."+field] = fieldSpec{typ: fieldFloat, unit: units[quantity], nullable: true}
+			}
+		}
+	}
+	for field, spec := range observationFields {
+		r.specs[field] = spec
+	}
+	addUnits("", observationUnitsFields)
+	for _, product := range historyProducts {
+		prefix := "history." + product + "."
+		for field, spec := range historyFields {
+			r.specs[prefix+field] = spec
+		}
+		addUnits(prefix, historyUnitsFields)
+	}
+
+	for _, path := range drop {
+		spec, ok := r.specs[path]
+		if !ok {
+			return nil, fmt.Errorf("cannot drop unknown field %q", path)
+		}
+		spec.drop = true
+		r.specs[path] = spec
+	}
+	return r, nil
+}
+
+func isUnknownFieldsPolicy(policy string) bool {
+	for _, p := range unknownFieldsPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultFieldRegistry is used by managers without a registry of their own.
+var defaultFieldRegistry, _ = newFieldRegistry(nil, unknownFieldsWrite)
+
+// value returns the value to write for the field, converted to its declared type.
+// It returns ok=false if the value should not be written:
+// the field is dropped, null, unknown (by policy), or not of its type.
+func (r *fieldRegistry) value(path string, v interface{}) (value interface{}, ok bool) {
+	spec, known := r.specs[path]
+	if !known {
+		r.warnOnce(path, "Unknown observation field", "policy", r.unknown)
+		if r.unknown != unknownFieldsWrite || v == nil {
+			return nil, false
+		}
+		return v, true
+	}
+	if spec.drop {
+		return nil, false
+	}
+	if v == nil {
+		if !spec.nullable {
+			log.Error("Observation field is null", "field", path)
+		}
+		return nil, false
+	}
+	value, err := spec.convert(v)
+	if err != nil {
+		log.Error("Observation field has the wrong type", "field", path, "value", v, "error", err)
+		return nil, false
+	}
+	return value, true
+}
+
+// warnOnce logs the warning the first time it is given for the path.
+func (r *fieldRegistry) warnOnce(path, msg string, ctx ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.warned[path] {
+		return
+	}
+	r.warned[path] = true
+	log.Warn(msg, append([]interface{}{"field", path}, ctx...)...)
+}
+
+// convert returns the value as the spec's type.
+// Numbers are converted between float and int (if it is whole); nothing else is converted.
+func (s fieldSpec) convert(v interface{}) (interface{}, error) {
+	switch s.typ {
+	case fieldFloat:
+		if f, ok := toFloat(v); ok {
+			return f, nil
+		}
+	case fieldInt:
+		if f, ok := toFloat(v); ok && f == math.Trunc(f) {
+			return int64(f), nil
+		}
+	case fieldString:
+		if str, ok := v.(string); ok {
+			return str, nil
+		}
+	case fieldBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case fieldTime:
+		if t, ok := v.(time.Time); ok {
+			return float64(t.Unix()), nil
+		}
+		if f, ok := toFloat(v); ok {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("%T is not %s", v, s.typ)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// fieldValue is fieldRegistry.value, with the manager's registry.
+func (m *managerInflux) fieldValue(path string, v interface{}) (interface{}, bool) {
+	r := m.fields
+	if r == nil {
+		r = defaultFieldRegistry
+	}
+	return r.value(path, v)
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFieldRegistry(t *testing.T) {
+	r, err := newFieldRegistry([]string{"metric.elev"}, unknownFieldsDrop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec := r.specs["imperial.temp"]; spec.typ != fieldFloat || spec.unit != "F" {
+		t.Errorf("imperial.temp: got %+v", spec)
+	}
+	if spec := r.specs["history.daily.metric.pressureTrend"]; spec.unit != "hPa/h" {
+		t.Errorf("history.daily.metric.pressureTrend: got %+v", spec)
+	}
+
+	for _, tc := range []struct {
+		path   string
+		v      interface{}
+		want   interface{}
+		wantOK bool
+	}{
+		{"metric.temp", 4.5, 4.5, true},
+		{"metric.temp", int64(4), 4.0, true},
+		{"metric.temp", "--", nil, false},
+		{"metric.temp", nil, nil, false},
+		{"metric.elev", 400.0, nil, false},
+		{"imperial.elev", 1312.0, 1312.0, true},
+		{"epoch", time.Unix(1634660430, 0), 1634660430.0, true},
+		{"epoch", 1634660430.0, 1634660430.0, true},
+		// Exact paths: a measurement merely containing "temp" is not a temperature.
+		{"metric.tempCustom", "n/a", nil, false},
+		{"stationTemperament", 1.0, nil, false},
+	} {
+		got, ok := r.value(tc.path, tc.v)
+		if ok != tc.wantOK || got != tc.want {
+			t.Errorf("%s=%#v: want %#v %v, got %#v %v", tc.path, tc.v, tc.want, tc.wantOK, got, ok)
+		}
+	}
+
+	// By default, unknown fields are written as they are.
+	if got, ok := defaultFieldRegistry.value("metric.tempCustom", "n/a"); !ok || got != "n/a" {
+		t.Errorf("want unknown field written, got %#v %v", got, ok)
+	}
+
+	if _, err := newFieldRegistry([]string{"metric.nope"}, unknownFieldsWrite); err == nil {
+		t.Error("want an error dropping an unknown field")
+	}
+	if _, err := newFieldRegistry(nil, "ignore"); err == nil {
+		t.Error("want an error for an invalid policy")
+	}
+}
+
+func TestFieldSpecConvert(t *testing.T) {
+	for _, tc := range []struct {
+		typ  string
+		v    interface{}
+		want interface{}
+	}{
+		{fieldInt, 3.0, int64(3)},
+		{fieldInt, 3.5, nil},
+		{fieldString, "Partly Cloudy", "Partly Cloudy"},
+		{fieldString, 1.0, nil},
+		{fieldBool, true, true},
+		{fieldBool, "true", nil},
+	} {
+		got, err := fieldSpec{typ: tc.typ}.convert(tc.v)
+		if got != tc.want || (err == nil) != (tc.want != nil) {
+			t.Errorf("%s %#v: want %#v, got %#v (%v)", tc.typ, tc.v, tc.want, got, err)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+	"github.com/tidwall/gjson"
+)
+
+// forecastLocationKeys are the location keys accepted by the v3 API, with patterns their values must match.
+var forecastLocationKeys = map[string]*regexp.Regexp{
+	"geocode":   regexp.MustCompile(`^-?[0-9.]+,-?[0-9.]+$`),
+	"postalKey": regexp.MustCompile(`^[A-Za-z0-9 -]+:[A-Z]{2}$`),
+	"iataCode":  regexp.MustCompile(`^[A-Z]{3}$`),
+	"icaoCode":  regexp.MustCompile(`^[A-Z]{4}$`),
+	"placeid":   regexp.MustCompile(`^[0-9a-f]+$`),
+}
+
+// forecastLocation is a location as addressed by the v3 API,
+// eg. geocode=48.029,-118.367 or postalKey=99129:US.
+type forecastLocation struct {
+	key   string
+	value string
+}
+
+// parseForecastLocation parses and validates a location given as key=value.
+func parseForecastLocation(s string) (forecastLocation, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return forecastLocation{}, fmt.Errorf("location %q is not key=value", s)
+	}
+	loc := forecastLocation{key: parts[0], value: strings.TrimSpace(parts[1])}
+	if loc.key == "iataCode" || loc.key == "icaoCode" {
+		loc.value = strings.ToUpper(loc.value)
+	}
+	return loc, loc.validate()
+}
+
+func (l forecastLocation) validate() error {
+	pattern, ok := forecastLocationKeys[l.key]
+	if !ok {
+		return fmt.Errorf("unknown location key %q (want geocode, postalKey, iataCode, icaoCode or placeid)", l.key)
+	}
+	if !pattern.MatchString(l.value) {
+		return fmt.Errorf("invalid %s: %q", l.key, l.value)
+	}
+	if l.key == "geocode" {
+		parts := strings.Split(l.value, ",")
+		lat, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return err
+		}
+		lon, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return err
+		}
+		if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			return errors.New("geocode out of range")
+		}
+	}
+	return nil
+}
+
+func (l forecastLocation) String() string {
+	return l.key + "=" + l.value
+}
+
+// forecastTarget is a location for which forecasts are requested.
+// Each target tracks its own forecast expiry.
+type forecastTarget struct {
+	location forecastLocation
+
+	// tags are added to the target's forecast points.
+	tags map[string]string
+
+	expiry           time.Time
+	hourlyExpiry     time.Time
+	officialExpiry   time.Time
+	airQualityExpiry time.Time
+	alertsNext       time.Time
+}
+
+// stationForecastTarget returns a forecast target at the station's coordinates,
+// or nil if the observation has none.
+func stationForecastTarget(station string, obs *pwsObservation) *forecastTarget {
+	if obs == nil || obs.Lat == nil || obs.Lon == nil {
+		return nil
+	}
+	target := newForecastTarget(forecastLocation{
+		key:   "geocode",
+		value: fmt.Sprintf("%.3f,%.3f", *obs.Lat, *obs.Lon),
+	})
+	target.tags["stationID"] = station
+	return target
+}
+
+// newForecastTarget returns a target whose forecast points are tagged with its location.
+func newForecastTarget(location forecastLocation) *forecastTarget {
+	return &forecastTarget{
+		location: location,
+		tags: map[string]string{
+			"locationKey": location.key,
+			"location":    location.value,
+		},
+	}
+}
+
+// addStationForecastTarget adds a forecast target for the station from its observation,
+// unless the station already has one.
+func (rc *runConfig) addStationForecastTarget(station string, obs *pwsObservation) {
+	if _, ok := rc.stationForecasts[station]; ok {
+		return
+	}
+	target := stationForecastTarget(station, obs)
+	if target == nil {
+		log.Warn("Station observation has no coordinates for a forecast", "station", station)
+		return
+	}
+	log.Info("Added station forecast", "station", station, "location", target.location)
+	rc.stationForecasts[station] = target
+	rc.forecastTargets = append(rc.forecastTargets, target)
+}
+
+// runForecasts requests and records the forecasts, official observations, air quality and alerts
+// of any targets whose data have expired.
+func (rc *runConfig) runForecasts() {
+	for _, target := range rc.forecastTargets {
+		if flagAppForecastEnable && time.Now().After(target.expiry) {
+			res, err := rc.manWU.requestForecast(target.location, flagAppForecastDays)
+			if err != nil {
+				log.Error("Forecast request failed", "location", target.location, "error", err)
+			} else if err := rc.managerInflux.recordForecast(res, target.tags); err != nil {
+				log.Error("Post InfluxDB forecast", "location", target.location, "error", err)
+			} else {
+				// Forecast has been recorded OK.
+				//
+				// Now get the expiry time from the forecast data,
+				// and update the target's value.
+				jval := gjson.GetBytes(res, "expirationTimeUtc.0")
+				target.expiry = time.Unix(jval.Int(), 0)
+				log.Info("Forecast expiry reset", "location", target.location, "expiry", target.expiry.Round(time.Second), "expiry.from_now", target.expiry.Sub(time.Now()).Round(time.Second))
+			}
+		}
+
+		if flagAppForecastHourly != "" && time.Now().After(target.hourlyExpiry) {
+			res, err := rc.manWU.requestForecastHourly(target.location, flagAppForecastHourly)
+			if err != nil {
+				log.Error("Hourly forecast request failed", "location", target.location, "error", err)
+			} else if err := rc.managerInflux.recordForecastHourly(flagAppForecastHourly, res, target.tags); err != nil {
+				log.Error("Post InfluxDB hourly forecast", "location", target.location, "error", err)
+			} else {
+				target.hourlyExpiry = res.expiry(time.Now())
+				log.Info("Hourly forecast expiry reset", "location", target.location, "expiry", target.hourlyExpiry.Round(time.Second), "expiry.from_now", target.hourlyExpiry.Sub(time.Now()).Round(time.Second))
+			}
+		}
+
+		if flagAppOfficialEnable && time.Now().After(target.officialExpiry) {
+			res, err := rc.manWU.requestOfficialCurrent(target.location)
+			if err != nil {
+				log.Error("Official observation request failed", "location", target.location, "error", err)
+			} else if err := rc.managerInflux.recordOfficialObs(res, target.tags); err != nil {
+				log.Error("Post InfluxDB official observation", "location", target.location, "error", err)
+			} else {
+				target.officialExpiry = res.expiry(time.Now())
+				log.Debug("Official observation expiry reset", "location", target.location, "expiry", target.officialExpiry.Round(time.Second))
+			}
+		}
+
+		if flagAppAirQualityEnable && time.Now().After(target.airQualityExpiry) {
+			res, err := rc.manWU.requestAirQuality(target.location)
+			if err != nil {
+				log.Error("Air quality request failed", "location", target.location, "error", err)
+			} else if err := rc.managerInflux.recordAirQuality(res, target.tags, time.Now()); err != nil {
+				log.Error("Post InfluxDB air quality", "location", target.location, "error", err)
+			} else {
+				target.airQualityExpiry = res.expiry(time.Now())
+				log.Debug("Air quality expiry reset", "location", target.location, "expiry", target.airQualityExpiry.Round(time.Second))
+			}
+		}
+
+		// Alerts have no expiry of their own, so they are polled at their own interval.
+		if flagAppAlertsEnable && time.Now().After(target.alertsNext) {
+			rc.runAlerts(target)
+			target.alertsNext = time.Now().Add(flagAppAlertsInterval)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// hourlyForecastProducts are the hourly forecast products, /v3/wx/forecast/hourly/<product>.
+var hourlyForecastProducts = []string{"2day", "15day"}
+
+// hourlyForecast is the response of the hourly forecast endpoints.
+// Each slice holds one value per forecast hour; values may be null.
+type hourlyForecast struct {
+	ExpirationTimeUtc []int64    `json:"expirationTimeUtc"`
+	ValidTimeUtc      []int64    `json:"validTimeUtc"`
+	Temperature       []*float64 `json:"temperature"`
+	PrecipChance      []*float64 `json:"precipChance"`
+	Qpf               []*float64 `json:"qpf"`
+	QpfSnow           []*float64 `json:"qpfSnow"`
+	WindSpeed         []*float64 `json:"windSpeed"`
+	WindGust          []*float64 `json:"windGust"`
+	WindDirection     []*float64 `json:"windDirection"`
+	CloudCover        []*float64 `json:"cloudCover"`
+	RelativeHumidity  []*float64 `json:"relativeHumidity"`
+}
+
+// hourlyForecastDefaultExpiry is used when the forecast has no expiry, or it has passed.
+const hourlyForecastDefaultExpiry = time.Hour
+
+// expiry returns the time at which the forecast should be requested again.
+func (f *hourlyForecast) expiry(now time.Time) time.Time {
+	if len(f.ExpirationTimeUtc) > 0 {
+		if t := time.Unix(f.ExpirationTimeUtc[0], 0); f.ExpirationTimeUtc[0] > 0 && t.After(now) {
+			return t
+		}
+	}
+	return now.Add(hourlyForecastDefaultExpiry)
+}
+
+// fields returns the non-null values of the forecast for hour i.
+func (f *hourlyForecast) fields(i int) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for name, values := range map[string][]*float64{
+		"temperature":      f.Temperature,
+		"precipChance":     f.PrecipChance,
+		"qpf":              f.Qpf,
+		"qpfSnow":          f.QpfSnow,
+		"windSpeed":        f.WindSpeed,
+		"windGust":         f.WindGust,
+		"windDirection":    f.WindDirection,
+		"cloudCover":       f.CloudCover,
+		"relativeHumidity": f.RelativeHumidity,
+	} {
+		if i < len(values) && values[i] != nil {
+			fields[name] = *values[i]
+		}
+	}
+	return fields
+}
+
+func isHourlyForecastProduct(product string) bool {
+	for _, p := range hourlyForecastProducts {
+		if p == product {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *managerWU) requestForecastHourly(location forecastLocation, product string) (res *hourlyForecast, err error) {
+	payload := url.Values{}
+	payload.Add(location.key, location.value)
+	payload.Add("format", "json")
+	payload.Add("units", "m")
+	payload.Add("language", "en-US")
+	dataBytes, err := m.get("/v3/wx/forecast/hourly/"+product, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the response body.
+	data := &hourlyForecast{}
+	err = json.Unmarshal(dataBytes, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// recordForecastHourly writes each forecast hour as a point at its valid time.
+// Newer forecasts for the same hour overwrite older ones.
+// The given tags (if any) are added to each point.
+func (m *managerInflux) recordForecastHourly(product string, forecast *hourlyForecast, extraTags map[string]string) error {
+	ctx := context.Background()
+	measurementName := fmt.Sprintf("%s/forecast/hourly", m.namespace)
+	tags := map[string]string{
+		"product": product,
+	}
+	for k, v := range extraTags {
+		tags[k] = v
+	}
+
+	for i, validTime := range forecast.ValidTimeUtc {
+		fields := forecast.fields(i)
+		if len(fields) == 0 {
+			continue
+		}
+		p := influxdb2.NewPoint(measurementName, tags, fields, time.Unix(validTime, 0))
+		if err := m.clientAPI.WritePoint(ctx, p); err != nil {
+			log.Error("Write point", "error", err)
+			return err
+		}
+	}
+	log.Debug("Wrote points", "type", "forecast", measurementName, len(forecast.ValidTimeUtc))
+	return nil
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRecordForecastHourly(t *testing.T) {
+	data := []byte(`{
+  "expirationTimeUtc": [1637081798, 1637081798],
+  "validTimeUtc": [1637082000, 1637085600],
+  "temperature": [4, 3],
+  "precipChance": [20, null],
+  "qpf": [0.1, 0],
+  "windSpeed": [11, 9],
+  "cloudCover": [80, 75]
+}`)
+	forecast := &hourlyForecast{}
+	if err := json.Unmarshal(data, forecast); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1637081000, 0)
+	if got := forecast.expiry(now); !got.Equal(time.Unix(1637081798, 0)) {
+		t.Errorf("expiry: got %v", got)
+	}
+	if got := (&hourlyForecast{}).expiry(now); !got.Equal(now.Add(hourlyForecastDefaultExpiry)) {
+		t.Errorf("want the default expiry without one, got %v", got)
+	}
+
+	api := &fakeWriteAPI{}
+	m := &managerInflux{clientAPI: api, namespace: "wu2."}
+	if err := m.recordForecastHourly("2day", forecast, map[string]string{"stationID": "KWAFRUIT1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.points) != 2 {
+		t.Fatalf("want 2 points, got %d", len(api.points))
+	}
+	if !api.points[1].Time().Equal(time.Unix(1637085600, 0)) {
+		t.Errorf("want point at valid time, got %v", api.points[1].Time())
+	}
+	if tags := api.points[0].TagList(); len(tags) != 2 {
+		t.Errorf("want product and stationID tags, got %v", tags)
+	}
+	for _, f := range api.points[1].FieldList() {
+		if f.Key == "precipChance" {
+			t.Error("null precipChance should be omitted")
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import "testing"
+
+func TestStationForecastTarget(t *testing.T) {
+	lat, lon := 48.029, -118.367
+	target := stationForecastTarget("KWAFRUIT1", &pwsObservation{Lat: &lat, Lon: &lon})
+	if target == nil {
+		t.Fatal("want target")
+	}
+	if target.location.String() != "geocode=48.029,-118.367" {
+		t.Errorf("location: got %q", target.location)
+	}
+	if target.tags["stationID"] != "KWAFRUIT1" {
+		t.Errorf("tags: got %v", target.tags)
+	}
+	if stationForecastTarget("KWAFRUIT1", &pwsObservation{}) != nil {
+		t.Error("want no target without coordinates")
+	}
+}
+
+func TestParseForecastLocation(t *testing.T) {
+	for _, s := range []string{
+		"geocode=48.033869303870034,-118.36167307821674",
+		"postalKey=99129:US",
+		"iataCode=geg",
+		"icaoCode=KGEG",
+		"placeid=9b1f8f1a2e0a6b4f3c6c4d2e3f7a8b9c",
+	} {
+		if _, err := parseForecastLocation(s); err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+	}
+	for _, s := range []string{
+		"48.029,-118.367",
+		"geocode=148.029,-118.367",
+		"postalKey=99129",
+		"icaoCode=GEG",
+		"zip=99129",
+	} {
+		if _, err := parseForecastLocation(s); err == nil {
+			t.Errorf("%s: want error", s)
+		}
+	}
+}
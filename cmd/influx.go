@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// isV1 returns true if the bucket is given in the slash-delimited db/retention form,
+// which is how the InfluxDB 1.8 compatibility API is addressed.
+func (m *managerInflux) isV1() bool {
+	return strings.Contains(m.bucket, "/")
+}
+
+// influxQLSeries is a series in the result of an InfluxQL query.
+type influxQLSeries struct {
+	Name    string            `json:"name"`
+	Tags    map[string]string `json:"tags"`
+	Columns []string          `json:"columns"`
+	Values  [][]interface{}   `json:"values"`
+}
+
+type influxQLResponse struct {
+	Results []struct {
+		Series []influxQLSeries `json:"series"`
+		Error  string           `json:"error"`
+	} `json:"results"`
+	Error string `json:"error"`
+}
+
+// queryInfluxQL runs an InfluxQL query against the v1 /query endpoint,
+// returning the series of the first statement.
+// Times are returned as epoch seconds.
+func (m *managerInflux) queryInfluxQL(q string) ([]influxQLSeries, error) {
+	if !m.isV1() {
+		return nil, errors.New("InfluxQL queries need a v1 db/retention bucket")
+	}
+	db, rp := splitV1Bucket(m.bucket)
+
+	payload := url.Values{}
+	payload.Add("db", db)
+	payload.Add("rp", rp)
+	payload.Add("epoch", "s")
+	payload.Add("q", q)
+	endpoint := strings.TrimSuffix(m.endpoint, "/") + "/query"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(payload.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if parts := strings.SplitN(m.token, ":", 2); len(parts) == 2 {
+		request.SetBasicAuth(parts[0], parts[1])
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	dataBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &influxQLResponse{}
+	if err := json.Unmarshal(dataBytes, res); err != nil {
+		return nil, fmt.Errorf("query failed: %d: %s", response.StatusCode, string(dataBytes))
+	}
+	if res.Error != "" {
+		return nil, errors.New(res.Error)
+	}
+	if len(res.Results) == 0 {
+		return nil, nil
+	}
+	if res.Results[0].Error != "" {
+		return nil, errors.New(res.Results[0].Error)
+	}
+	return res.Results[0].Series, nil
+}
+
+func splitV1Bucket(bucket string) (db, rp string) {
+	parts := strings.SplitN(bucket, "/", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// quoteInfluxQLString quotes s as an InfluxQL string literal.
+func quoteInfluxQLString(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `'`, `\'`) + "'"
+}
+
+// quoteInfluxQLIdent quotes s as an InfluxQL identifier.
+func quoteInfluxQLIdent(s string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+}
+
+// lastGaugeValue returns the most recent value of the station's gauge measurement,
+// looking back no further than the given window.
+// It returns ok=false if there is no value in the window.
+func (m *managerInflux) lastGaugeValue(measurement, station string, window time.Duration) (value float64, ok bool, err error) {
+	return m.lastFieldValue(measurement, "value", station, window)
+}
+
+// lastFieldValue is like lastGaugeValue, for the given field of the measurement.
+func (m *managerInflux) lastFieldValue(measurement, field, station string, window time.Duration) (value float64, ok bool, err error) {
+	if m.isV1() {
+		q := fmt.Sprintf(`SELECT last(%s) FROM %s WHERE "stationID" = %s AND time > now() - %ds`,
+			quoteInfluxQLIdent(field), quoteInfluxQLIdent(measurement), quoteInfluxQLString(station), int64(window.Seconds()))
+		series, err := m.queryInfluxQL(q)
+		if err != nil {
+			return 0, false, err
+		}
+		if len(series) == 0 || len(series[0].Values) == 0 || len(series[0].Values[0]) < 2 {
+			return 0, false, nil
+		}
+		value, ok = series[0].Values[0][1].(float64)
+		return value, ok, nil
+	}
+
+	// The station can have several series (eg. from before it was tagged),
+	// so group away the other tags to get the last value of them all.
+	flux := fmt.Sprintf(`from(bucket: %q)
+  |> range(start: -%ds)
+  |> filter(fn: (r) => r._measurement == %q and r._field == %q and r.stationID == %q)
+  |> group(columns: ["_measurement", "_field", "stationID"])
+  |> last()`, m.bucket, int64(window.Seconds()), measurement, field, station)
+	result, err := m.client.QueryAPI(m.org).Query(context.Background(), flux)
+	if err != nil {
+		return 0, false, err
+	}
+	defer result.Close()
+	// Values of different types are still in tables of their own.
+	var last time.Time
+	for result.Next() {
+		r := result.Record()
+		if v, isFloat := r.Value().(float64); isFloat && (!ok || r.Time().After(last)) {
+			value, ok, last = v, true, r.Time()
+		}
+	}
+	if err := result.Err(); err != nil {
+		return 0, false, err
+	}
+	return value, ok, nil
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+func TestLastObservationTimeV1(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/query" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "eve" || pass != "secret" {
+			t.Errorf("unexpected auth: %s:%s", user, pass)
+		}
+		if got := r.FormValue("db"); got != "dbriverrun" {
+			t.Errorf("db: got %q", got)
+		}
+		w.Write([]byte(`{"results":[{"statement_id":0,"series":[{"name":"wu2.epoch.gauge","columns":["time","last"],"values":[[1634660431,1634660430]]}]}]}`))
+	}))
+	defer srv.Close()
+
+	m := &managerInflux{
+		endpoint:  srv.URL,
+		token:     "eve:secret",
+		bucket:    "dbriverrun/autogen",
+		namespace: "wu2.",
+	}
+	last, err := m.lastObservationTime("KWAFRUIT1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.Equal(time.Unix(1634660430, 0)) {
+		t.Errorf("got %v", last)
+	}
+}
+
+func TestLastObservationTimeFlux(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/query" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if !strings.Contains(string(body), `group(columns: [\"_measurement\", \"_field\", \"stationID\"])`) {
+			t.Errorf("want the series grouped by station, got %s", body)
+		}
+		// The untagged series, then the newer tagged one.
+		w.Header().Set("Content-Type", "text/csv")
+		w.Write([]byte("#datatype,string,long,dateTime:RFC3339,double,string,string,string\n" +
+			"#group,false,false,false,false,true,true,true\n" +
+			"#default,_result,,,,,,\n" +
+			",result,table,_time,_value,_field,_measurement,stationID\n" +
+			",,0,2021-10-18T12:00:30Z,1634558430,value,wu2.epoch.gauge,KWAFRUIT1\n" +
+			",,1,2021-10-19T16:20:30Z,1634660430,value,wu2.epoch.gauge,KWAFRUIT1\n\n"))
+	}))
+	defer srv.Close()
+
+	m := &managerInflux{
+		client:    influxdb2.NewClient(srv.URL, "token"),
+		endpoint:  srv.URL,
+		token:     "token",
+		org:       "home",
+		bucket:    "weather",
+		namespace: "wu2.",
+	}
+	last, err := m.lastObservationTime("KWAFRUIT1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.Equal(time.Unix(1634660430, 0)) {
+		t.Errorf("want the newest observation, got %v", last)
+	}
+}
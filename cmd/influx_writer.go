@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+	influxdb2_api "github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// batchWriter adapts the client's batching, asynchronous WriteAPI
+// to the blocking interface the collectors write with.
+// Writes are queued and return at once; the client retries 5xx and network errors
+// with backoff, and failures are passed to the error callback.
+type batchWriter struct {
+	api  influxdb2_api.WriteAPI
+	done chan struct{}
+}
+
+func newBatchWriter(api influxdb2_api.WriteAPI, onError func(error)) *batchWriter {
+	w := &batchWriter{api: api, done: make(chan struct{})}
+	errs := api.Errors()
+	go func() {
+		defer close(w.done)
+		for err := range errs {
+			onError(err)
+		}
+	}()
+	return w
+}
+
+func (w *batchWriter) WriteRecord(ctx context.Context, line ...string) error {
+	for _, l := range line {
+		w.api.WriteRecord(l)
+	}
+	return nil
+}
+
+func (w *batchWriter) WritePoint(ctx context.Context, point ...*write.Point) error {
+	for _, p := range point {
+		w.api.WritePoint(p)
+	}
+	return nil
+}
+
+// Flush writes the pending batch.
+func (w *batchWriter) Flush() {
+	w.api.Flush()
+}
+
+// writeErrors counts failed writes, and passes on any field type conflicts they report.
+type writeErrors struct {
+	count int64
+
+	conflicts *typeConflicts
+}
+
+// handle is the error callback of the batch writer.
+// With batching, a field type conflict can't be retried point by point,
+// so it is remembered for the next time.
+func (e *writeErrors) handle(err error) {
+	if e == nil {
+		return
+	}
+	atomic.AddInt64(&e.count, 1)
+	log.Error("Write batch", "error", err)
+	e.conflicts.addFromError(err)
+}
+
+// total returns the number of failed writes.
+func (e *writeErrors) total() int64 {
+	if e == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&e.count)
+}
+
+// close flushes any pending batch and closes the client.
+func (m *managerInflux) close() {
+	if m.client != nil {
+		// Closing the client flushes and closes its write APIs.
+		m.client.Close()
+	}
+	if m.writer != nil {
+		<-m.writer.done
+	}
+}
+
+// errStopped is returned by a wait which was cut short by a stop.
+var errStopped = errors.New("stopped")
+
+// stopOnSignal returns a channel which is closed when the process is interrupted
+// or terminated. The collectors stop at their next checkpoint, and the caller
+// then closes the manager, flushing the final batch.
+// A second signal kills the process.
+func stopOnSignal() <-chan struct{} {
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-sig
+		signal.Stop(sig)
+		log.Warn("Shutting down", "signal", s)
+		close(stop)
+	}()
+	return stop
+}
+
+// stopped reports whether stop has been closed.
+// A nil channel never stops.
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleepUntilStopped sleeps for d, or until stop is closed.
+// It returns false if it was stopped.
+func sleepUntilStopped(stop <-chan struct{}, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-stop:
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+func TestBatchWriter(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, string(b))
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	options := influxdb2.DefaultOptions().SetBatchSize(3).SetFlushInterval(60000).SetRetryInterval(1).SetMaxRetries(3)
+	c := influxdb2.NewClientWithOptions(srv.URL, "token", options)
+	errs := &writeErrors{}
+	w := newBatchWriter(c.WriteAPI("org", "bucket"), errs.handle)
+	m := &managerInflux{client: c, clientAPI: w, writer: w, writeErrors: errs, namespace: "wu2.", currentStation: "KWAFRUIT1"}
+
+	// The first batch fails with a 503, and is retried with the next.
+	m.postGauges("", map[string]interface{}{"a": 1.0, "b": 2.0, "c": 3.0}, nil, time.Now())
+	time.Sleep(50 * time.Millisecond)
+	m.postGauges("", map[string]interface{}{"d": 4.0}, nil, time.Now())
+	m.close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) < 2 {
+		t.Fatalf("want the failed batch retried, got %d requests", len(requests))
+	}
+	written := strings.Join(requests[1:], "\n")
+	for _, name := range []string{"wu2.a.gauge", "wu2.b.gauge", "wu2.c.gauge", "wu2.d.gauge"} {
+		if !strings.Contains(written, name) {
+			t.Errorf("want %s written, got %q", name, written)
+		}
+	}
+	if errs.total() != 1 {
+		t.Errorf("want 1 write error, got %d", errs.total())
+	}
+}
+
+func TestWriteErrorsTypeConflict(t *testing.T) {
+	conflicts := &typeConflicts{}
+	errs := &writeErrors{conflicts: conflicts}
+	errs.handle(errors.New(`400 Bad Request: partial write: field type conflict: input field "value" on measurement "wu2.metric.windChill.gauge" is type float, already exists as type string dropped=1`))
+	if typ, ok := conflicts.existingType("wu2.metric.windChill.gauge", "value"); !ok || typ != "string" {
+		t.Errorf("want the conflict remembered, got %q %v", typ, ok)
+	}
+
+	// Numbers are never written as strings; with the suffix policy they go to a suffixed field.
+	api := &fakeWriteAPI{}
+	m := &managerInflux{clientAPI: api, conflicts: conflicts, conflictPolicy: typeConflictsSuffix, namespace: "wu2.", currentStation: "KWAFRUIT1"}
+	m.postGauges("metric", map[string]interface{}{"windChill": -1.5, "temp": 2.5}, nil, time.Now())
+	if len(api.points) != 2 {
+		t.Fatalf("want 2 points, got %d", len(api.points))
+	}
+	for _, pt := range api.points {
+		f := pt.FieldList()[0]
+		wantKey := "value"
+		if pt.Name() == "wu2.metric.windChill.gauge" {
+			wantKey = "value_float"
+		}
+		if _, ok := f.Value.(float64); !ok || f.Key != wantKey {
+			t.Errorf("%s: want float %s, got %s=%#v", pt.Name(), wantKey, f.Key, f.Value)
+		}
+	}
+
+	// With the refuse policy, the field is left as it is.
+	m.conflictPolicy = typeConflictsRefuse
+	if key := m.fieldKey("wu2.metric.windChill.gauge", "value", -1.5); key != "value" {
+		t.Errorf("want value, got %s", key)
+	}
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// Station liveness events.
+const (
+	stationEventOffline = "offline"
+	stationEventOnline  = "online"
+)
+
+// checkLiveness updates the station's liveness from the time of its last observation.
+// A station is offline once it has not reported for the offline threshold,
+// whether the API responds with no content or with an observation which hasn't moved.
+// It writes the station_online and seconds_since_last_observation gauges,
+// and an event when the station goes offline or comes back.
+// Stations which have never been observed are not checked.
+func (rc *runConfig) checkLiveness(station string, now time.Time) {
+	state := getStationState(station)
+	if state.LastEpoch == 0 || rc.offlineAfter <= 0 {
+		return
+	}
+	last := time.Unix(state.LastEpoch, 0)
+	since := now.Sub(last)
+	online := since < rc.offlineAfter
+
+	rc.managerInflux.postAppGaugeTagged("seconds_since_last_observation", map[string]string{"stationID": station}, since.Seconds())
+	onlineValue := 0.0
+	if online {
+		onlineValue = 1
+	}
+	rc.managerInflux.postAppGaugeTagged("station_online", map[string]string{"stationID": station}, onlineValue)
+
+	if online != state.Offline {
+		// No change.
+		return
+	}
+	event := stationEventOffline
+	if online {
+		event = stationEventOnline
+		log.Info("Station is back online", "station", station, "offline", now.Sub(time.Unix(state.OfflineSince, 0)).Round(time.Second))
+	} else {
+		log.Warn("Station is offline", "station", station, "lastObservation", last, "since", since.Round(time.Second))
+	}
+	if err := rc.managerInflux.recordStationEvent(station, event, last, now); err != nil {
+		return
+	}
+	state.Offline = !online
+	state.OfflineSince = 0
+	if !online {
+		state.OfflineSince = now.Unix()
+	}
+	saveStationState(station, state)
+}
+
+// recordStationEvent writes a station liveness event, tagged with the station and event.
+func (m *managerInflux) recordStationEvent(station, event string, lastObservation, now time.Time) error {
+	measurementName := fmt.Sprintf("%sstation.event", m.namespace)
+	tags := map[string]string{
+		"stationID": station,
+		"event":     event,
+	}
+	fields := map[string]interface{}{
+		"lastObservation":             lastObservation.Unix(),
+		"secondsSinceLastObservation": now.Sub(lastObservation).Seconds(),
+	}
+	p := influxdb2.NewPoint(measurementName, tags, fields, now)
+	if err := m.clientAPI.WritePoint(context.Background(), p); err != nil {
+		log.Error("Write station event", "station", station, "event", event, "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckLiveness(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+
+	api := &fakeWriteAPI{}
+	rc := &runConfig{
+		managerInflux: &managerInflux{clientAPI: api, namespace: "wu2."},
+		offlineAfter:  30 * time.Minute,
+	}
+	events := func() []string {
+		var events []string
+		for _, pt := range api.points {
+			if pt.Name() != "wu2.station.event" {
+				continue
+			}
+			for _, tag := range pt.TagList() {
+				if tag.Key == "event" {
+					events = append(events, tag.Value)
+				}
+			}
+		}
+		return events
+	}
+
+	// Never observed: unknown, so nothing is written.
+	now := time.Unix(1634660430, 0)
+	rc.checkLiveness("KWAFRUIT1", now)
+	if len(api.points) != 0 {
+		t.Fatalf("want no points for an unobserved station, got %d", len(api.points))
+	}
+
+	saveStationState("KWAFRUIT1", &stationState{LastEpoch: now.Unix()})
+	rc.checkLiveness("KWAFRUIT1", now.Add(time.Minute))
+	if got := events(); len(got) != 0 {
+		t.Errorf("want no events while online, got %v", got)
+	}
+
+	// The observation hasn't moved for longer than the threshold.
+	rc.checkLiveness("KWAFRUIT1", now.Add(time.Hour))
+	rc.checkLiveness("KWAFRUIT1", now.Add(2*time.Hour))
+	if got := events(); len(got) != 1 || got[0] != stationEventOffline {
+		t.Errorf("want a single offline event, got %v", got)
+	}
+	if !getStationState("KWAFRUIT1").Offline {
+		t.Error("want offline persisted")
+	}
+
+	last := api.points[len(api.points)-1]
+	if last.Name() != "wu2.app.station_online.gauge" || last.FieldList()[0].Value != 0.0 {
+		t.Errorf("want station_online 0, got %s %v", last.Name(), last.FieldList()[0].Value)
+	}
+
+	// A new observation arrives.
+	state := getStationState("KWAFRUIT1")
+	state.LastEpoch = now.Add(3 * time.Hour).Unix()
+	saveStationState("KWAFRUIT1", state)
+	rc.checkLiveness("KWAFRUIT1", now.Add(3*time.Hour+time.Minute))
+	if got := events(); len(got) != 2 || got[1] != stationEventOnline {
+		t.Errorf("want an online event, got %v", got)
+	}
+	if getStationState("KWAFRUIT1").Offline {
+		t.Error("want online persisted")
+	}
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// postAppGauge writes a gauge about the ETL itself (rather than the weather),
+// as the measurement <namespace>app.<name>.gauge, tagged with the current station.
+func (m *managerInflux) postAppGauge(name string, value float64) {
+	m.postAppGaugeTagged(name, map[string]string{"stationID": m.currentStation}, value)
+}
+
+// postAppGaugeTagged is like postAppGauge, but with the given tags.
+func (m *managerInflux) postAppGaugeTagged(name string, tags map[string]string, value float64) {
+	measurement := m.namespace + "app." + name + ".gauge"
+	fields := map[string]interface{}{
+		"value": value,
+	}
+	pt := influxdb2.NewPoint(measurement, tags, fields, time.Now())
+	if err := m.clientAPI.WritePoint(context.Background(), pt); err != nil {
+		log.Error("Write app gauge", "measurement", measurement, "error", err)
+	}
+}
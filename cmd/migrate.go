@@ -0,0 +1,304 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/spf13/cobra"
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Repair data written by earlier versions",
+}
+
+// migrateFixTypesCmd represents the migrate fix-types command
+var migrateFixTypesCmd = &cobra.Command{
+	Use:   "fix-types",
+	Short: "Repair numeric gauges which were written as strings",
+	Long: `Earlier versions handled a field type conflict by writing the number as a string,
+so gauges such as wu.winddir.gauge and wu.metric.temp.gauge have string values
+in places, and charts of them have holes.
+
+fix-types reads the numeric gauges of the field registry in each namespace
+(--migrate_namespaces), or the given measurements (--migrate_measurements).
+The string values are parsed back to floats and written, with the float values
+(including any written to value_float since), to a corrected measurement,
+eg. wu.metric.temp.fixed.gauge.
+Measurements without string values are left alone.
+
+With --migrate_drop, the data of each broken measurement within the range
+is deleted once it has been corrected without errors.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		setupLogging()
+
+		from, to, err := parseBackfillRange(flagMigrateFrom, flagMigrateTo)
+		if err != nil {
+			log.Crit("Invalid migrate range", "error", err)
+		}
+		if flagMigrateChunk <= 0 {
+			log.Crit("Invalid migrate chunk", "chunk", flagMigrateChunk)
+		}
+
+		fc := &fixTypesConfig{
+			managerInflux: newManagerInflux(),
+			measurements:  flagMigrateMeasurements,
+			from:          from,
+			to:            to.AddDate(0, 0, 1),
+			chunk:         flagMigrateChunk,
+			drop:          flagMigrateDrop,
+		}
+		if len(fc.measurements) == 0 {
+			fc.measurements = numericGaugeMeasurements(fc.managerInflux.fields, flagMigrateNamespaces)
+		}
+
+		fixTypes(fc)
+		fc.managerInflux.close()
+	},
+}
+
+var flagMigrateNamespaces []string
+var flagMigrateMeasurements []string
+var flagMigrateFrom string
+var flagMigrateTo string
+var flagMigrateChunk time.Duration
+var flagMigrateDrop bool
+
+// migrateFixedSuffix is inserted before .gauge to name a corrected measurement.
+const migrateFixedSuffix = ".fixed"
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.AddCommand(migrateFixTypesCmd)
+
+	migrateCmd.PersistentFlags().StringSliceVar(&flagMigrateNamespaces, "migrate_namespaces", []string{"wu.", "wu2."}, "Namespaces of the gauges to repair")
+	migrateCmd.PersistentFlags().StringSliceVar(&flagMigrateMeasurements, "migrate_measurements", nil, "Measurements to repair, instead of the registry's numeric gauges")
+	migrateCmd.PersistentFlags().StringVar(&flagMigrateFrom, "migrate_from", "", "First day to repair (YYYY-MM-DD)")
+	migrateCmd.PersistentFlags().StringVar(&flagMigrateTo, "migrate_to", "", "Last day to repair (YYYY-MM-DD). Default is today.")
+	migrateCmd.PersistentFlags().DurationVar(&flagMigrateChunk, "migrate_chunk", 7*24*time.Hour, "Time range read and written at once")
+	migrateCmd.PersistentFlags().BoolVar(&flagMigrateDrop, "migrate_drop", false, "Delete the range from each broken measurement once it has been corrected")
+}
+
+type fixTypesConfig struct {
+	managerInflux *managerInflux
+	measurements  []string
+	from, to      time.Time
+	chunk         time.Duration
+	drop          bool
+}
+
+// numericGaugeMeasurements returns the gauge measurements of the registry's numeric fields,
+// in each namespace.
+func numericGaugeMeasurements(r *fieldRegistry, namespaces []string) []string {
+	if r == nil {
+		r = defaultFieldRegistry
+	}
+	var measurements []string
+	for _, ns := range namespaces {
+		for path, spec := range r.specs {
+			if spec.typ == fieldFloat || spec.typ == fieldTime {
+				measurements = append(measurements, ns+path+".gauge")
+			}
+		}
+	}
+	return measurements
+}
+
+// fixedMeasurement returns the name of the corrected measurement.
+func fixedMeasurement(measurement string) string {
+	return strings.TrimSuffix(measurement, ".gauge") + migrateFixedSuffix + ".gauge"
+}
+
+// gaugeValue is a value read from a gauge measurement.
+type gaugeValue struct {
+	time  time.Time
+	tags  map[string]string
+	value interface{}
+}
+
+// parseGaugeValue returns the value as a float, parsing strings.
+func parseGaugeValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	default:
+		return toFloat(v)
+	}
+}
+
+func fixTypes(fc *fixTypesConfig) {
+	m := fc.managerInflux
+	api := m.client.WriteAPIBlocking(m.org, m.bucket)
+
+	for _, measurement := range fc.measurements {
+		damaged, err := fc.damaged(measurement)
+		if err != nil {
+			log.Error("Check measurement for string values", "measurement", measurement, "error", err)
+			continue
+		}
+		if !damaged {
+			log.Debug("No string values", "measurement", measurement)
+			continue
+		}
+
+		var written, parsed, unparsable int
+		failed := false
+		for start := fc.from; start.Before(fc.to); start = start.Add(fc.chunk) {
+			stop := start.Add(fc.chunk)
+			if stop.After(fc.to) {
+				stop = fc.to
+			}
+			values, err := m.readGaugeValues(measurement, start, stop)
+			if err != nil {
+				log.Error("Read gauge values", "measurement", measurement, "start", start, "error", err)
+				failed = true
+				break
+			}
+
+			var points []*write.Point
+			for _, v := range values {
+				f, ok := parseGaugeValue(v.value)
+				if !ok {
+					log.Debug("Unparsable gauge value", "measurement", measurement, "time", v.time, "value", v.value)
+					unparsable++
+					continue
+				}
+				if _, isString := v.value.(string); isString {
+					parsed++
+				}
+				points = append(points, influxdb2.NewPoint(fixedMeasurement(measurement), v.tags, map[string]interface{}{"value": f}, v.time))
+			}
+			if len(points) == 0 {
+				continue
+			}
+			if err := api.WritePoint(context.Background(), points...); err != nil {
+				log.Error("Write corrected gauge values", "measurement", fixedMeasurement(measurement), "start", start, "error", err)
+				failed = true
+				break
+			}
+			written += len(points)
+		}
+
+		log.Info("Corrected measurement", "measurement", measurement, "to", fixedMeasurement(measurement),
+			"written", written, "parsed", parsed, "unparsable", unparsable, "failed", failed)
+
+		if fc.drop && !failed {
+			// Only the range has been corrected, so only the range may go.
+			if err := m.deleteRange(measurement, fc.from, fc.to); err != nil {
+				log.Error("Delete broken measurement range", "measurement", measurement, "error", err)
+			} else {
+				log.Info("Deleted broken measurement range", "measurement", measurement, "from", fc.from, "to", fc.to)
+			}
+		}
+	}
+}
+
+// damaged returns true if the gauge measurement has string values.
+// On v1 the field's types are listed by SHOW FIELD KEYS; on v2 the range is read until one is found.
+func (fc *fixTypesConfig) damaged(measurement string) (bool, error) {
+	m := fc.managerInflux
+	if m.isV1() {
+		series, err := m.queryInfluxQL("SHOW FIELD KEYS FROM " + quoteInfluxQLIdent(measurement))
+		if err != nil {
+			return false, err
+		}
+		for _, s := range series {
+			for _, row := range s.Values {
+				if len(row) >= 2 && row[0] == "value" && row[1] == "string" {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+
+	for start := fc.from; start.Before(fc.to); start = start.Add(fc.chunk) {
+		values, err := m.readGaugeValues(measurement, start, start.Add(fc.chunk))
+		if err != nil {
+			return false, err
+		}
+		for _, v := range values {
+			if _, ok := v.value.(string); ok {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// readGaugeValues returns the values of the gauge measurement in [start, stop),
+// of whatever type they were written as, including any written to value_float
+// because of a type conflict (see fieldKey).
+func (m *managerInflux) readGaugeValues(measurement string, start, stop time.Time) ([]gaugeValue, error) {
+	var values []gaugeValue
+
+	if m.isV1() {
+		// A field can have a different type in each shard; InfluxQL reads one type at a time.
+		for _, field := range []struct{ key, typ string }{
+			{"value", "float"},
+			{"value", "integer"},
+			{"value", "string"},
+			{"value_float", "float"},
+		} {
+			q := fmt.Sprintf(`SELECT %s::%s FROM %s WHERE time >= %s AND time < %s GROUP BY *`,
+				quoteInfluxQLIdent(field.key), field.typ, quoteInfluxQLIdent(measurement),
+				quoteInfluxQLString(start.UTC().Format(time.RFC3339)), quoteInfluxQLString(stop.UTC().Format(time.RFC3339)))
+			series, err := m.queryInfluxQL(q)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range series {
+				for _, row := range s.Values {
+					if len(row) < 2 || row[1] == nil {
+						continue
+					}
+					epoch, _ := row[0].(float64)
+					values = append(values, gaugeValue{time: time.Unix(int64(epoch), 0), tags: s.Tags, value: row[1]})
+				}
+			}
+		}
+		return values, nil
+	}
+
+	flux := fmt.Sprintf(`from(bucket: %q)
+  |> range(start: %s, stop: %s)
+  |> filter(fn: (r) => r._measurement == %q and (r._field == "value" or r._field == "value_float"))`,
+		m.bucket, start.UTC().Format(time.RFC3339), stop.UTC().Format(time.RFC3339), measurement)
+	result, err := m.client.QueryAPI(m.org).Query(context.Background(), flux)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+	for result.Next() {
+		r := result.Record()
+		tags := map[string]string{}
+		for k, v := range r.Values() {
+			if s, ok := v.(string); ok && !strings.HasPrefix(k, "_") && k != "result" && k != "table" {
+				tags[k] = s
+			}
+		}
+		values = append(values, gaugeValue{time: r.Time(), tags: tags, value: r.Value()})
+	}
+	return values, result.Err()
+}
+
+// deleteRange deletes the measurement's data in [start, stop).
+func (m *managerInflux) deleteRange(measurement string, start, stop time.Time) error {
+	if m.isV1() {
+		q := fmt.Sprintf(`DELETE FROM %s WHERE time >= %s AND time < %s`, quoteInfluxQLIdent(measurement),
+			quoteInfluxQLString(start.UTC().Format(time.RFC3339)), quoteInfluxQLString(stop.UTC().Format(time.RFC3339)))
+		_, err := m.queryInfluxQL(q)
+		return err
+	}
+	// The v2 delete range includes its stop.
+	predicate := fmt.Sprintf(`_measurement=%q`, measurement)
+	return m.client.DeleteAPI().DeleteWithName(context.Background(), m.org, m.bucket, start, stop.Add(-time.Nanosecond), predicate)
+}
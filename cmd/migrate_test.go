@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+func TestFixTypesV1(t *testing.T) {
+	var mu sync.Mutex
+	var written []string
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/api/v2/write" {
+			b, _ := ioutil.ReadAll(r.Body)
+			written = append(written, strings.Split(strings.TrimSpace(string(b)), "\n")...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		q := r.FormValue("q")
+		switch {
+		case q == `SHOW FIELD KEYS FROM "wu.metric.temp.gauge"`:
+			w.Write([]byte(`{"results":[{"series":[{"name":"wu.metric.temp.gauge","columns":["fieldKey","fieldType"],"values":[["value","float"],["value","string"]]}]}]}`))
+		case strings.HasPrefix(q, "SHOW FIELD KEYS"):
+			w.Write([]byte(`{"results":[{"series":[{"name":"wu.humidity.gauge","columns":["fieldKey","fieldType"],"values":[["value","float"]]}]}]}`))
+		case strings.HasPrefix(q, `SELECT "value"::string FROM "wu.metric.temp.gauge"`):
+			w.Write([]byte(`{"results":[{"series":[{"name":"wu.metric.temp.gauge","tags":{"stationID":"KWAFRUIT1"},"columns":["time","value"],"values":[[1670276748,"4.5"],[1670277048,"--"]]}]}]}`))
+		case strings.HasPrefix(q, `SELECT "value"::float FROM "wu.metric.temp.gauge"`):
+			w.Write([]byte(`{"results":[{"series":[{"name":"wu.metric.temp.gauge","tags":{"stationID":"KWAFRUIT1"},"columns":["time","value"],"values":[[1670276448,4.25]]}]}]}`))
+		case strings.HasPrefix(q, "SELECT"):
+			w.Write([]byte(`{"results":[{}]}`))
+		case strings.HasPrefix(q, "DELETE FROM"):
+			deleted = append(deleted, q)
+			w.Write([]byte(`{"results":[{}]}`))
+		default:
+			t.Errorf("unexpected query: %s", q)
+		}
+	}))
+	defer srv.Close()
+
+	m := &managerInflux{
+		client:    influxdb2.NewClient(srv.URL, "eve:secret"),
+		endpoint:  srv.URL,
+		token:     "eve:secret",
+		bucket:    "weather/autogen",
+		namespace: "wu2.",
+	}
+	fc := &fixTypesConfig{
+		managerInflux: m,
+		measurements:  []string{"wu.metric.temp.gauge", "wu.humidity.gauge"},
+		from:          time.Date(2022, 12, 5, 0, 0, 0, 0, time.UTC),
+		to:            time.Date(2022, 12, 6, 0, 0, 0, 0, time.UTC),
+		chunk:         24 * time.Hour,
+		drop:          true,
+	}
+	fixTypes(fc)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"wu.metric.temp.fixed.gauge,stationID=KWAFRUIT1 value=4.25 1670276448000000000",
+		"wu.metric.temp.fixed.gauge,stationID=KWAFRUIT1 value=4.5 1670276748000000000",
+	}
+	if strings.Join(written, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want written:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(written, "\n"))
+	}
+	if len(deleted) != 1 || deleted[0] != `DELETE FROM "wu.metric.temp.gauge" WHERE time >= '2022-12-05T00:00:00Z' AND time < '2022-12-06T00:00:00Z'` {
+		t.Errorf("want only the range of the broken measurement deleted, got %v", deleted)
+	}
+}
+
+func TestFixTypesV2DeletesOnlyTheRange(t *testing.T) {
+	var mu sync.Mutex
+	var deletes []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/api/v2/query":
+			// The measurement has data before and after the range; only the range is read.
+			w.Header().Set("Content-Type", "text/csv")
+			w.Write([]byte("#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,dateTime:RFC3339,string,string,string,string\n" +
+				"#group,false,false,true,true,false,false,true,true,true\n" +
+				"#default,_result,,,,,,,,\n" +
+				",result,table,_start,_stop,_time,_value,_field,_measurement,stationID\n" +
+				",,0,2022-12-05T00:00:00Z,2022-12-06T00:00:00Z,2022-12-05T21:45:48Z,4.5,value,wu.metric.temp.gauge,KWAFRUIT1\n\n"))
+		case "/api/v2/write":
+			w.WriteHeader(http.StatusNoContent)
+		case "/api/v2/delete":
+			body := map[string]string{}
+			json.NewDecoder(r.Body).Decode(&body)
+			deletes = append(deletes, body)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request: %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	m := &managerInflux{
+		client:    influxdb2.NewClient(srv.URL, "token"),
+		endpoint:  srv.URL,
+		token:     "token",
+		org:       "home",
+		bucket:    "weather",
+		namespace: "wu2.",
+	}
+	fc := &fixTypesConfig{
+		managerInflux: m,
+		measurements:  []string{"wu.metric.temp.gauge"},
+		from:          time.Date(2022, 12, 5, 0, 0, 0, 0, time.UTC),
+		to:            time.Date(2022, 12, 6, 0, 0, 0, 0, time.UTC),
+		chunk:         24 * time.Hour,
+		drop:          true,
+	}
+	fixTypes(fc)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deletes) != 1 {
+		t.Fatalf("want one delete, got %v", deletes)
+	}
+	d := deletes[0]
+	if d["predicate"] != `_measurement="wu.metric.temp.gauge"` {
+		t.Errorf("unexpected predicate: %s", d["predicate"])
+	}
+	start, _ := time.Parse(time.RFC3339Nano, d["start"])
+	stop, _ := time.Parse(time.RFC3339Nano, d["stop"])
+	if !start.Equal(fc.from) || !stop.Before(fc.to) || stop.Before(fc.to.Add(-time.Second)) {
+		t.Errorf("want the delete limited to %s..%s, got %s..%s", fc.from, fc.to, d["start"], d["stop"])
+	}
+}
+
+func TestNumericGaugeMeasurements(t *testing.T) {
+	measurements := numericGaugeMeasurements(nil, []string{"wu."})
+	found := map[string]bool{}
+	for _, m := range measurements {
+		found[m] = true
+	}
+	for _, m := range []string{"wu.winddir.gauge", "wu.metric.temp.gauge", "wu.epoch.gauge"} {
+		if !found[m] {
+			t.Errorf("want %s", m)
+		}
+	}
+	if got := fixedMeasurement("wu.winddir.gauge"); got != "wu.winddir.fixed.gauge" {
+		t.Errorf("fixedMeasurement: got %s", got)
+	}
+}
@@ -0,0 +1,321 @@
+package cmd
+
+import "time"
+
+// weatherUndergroundObservations is the envelope returned by the PWS observation endpoints.
+type weatherUndergroundObservations struct {
+	Observations []*pwsObservation `json:"observations"`
+}
+
+// pwsObservation is a single Personal Weather Station observation,
+// as returned by /v2/pws/observations/current.
+// Fields the API may send as null are pointers.
+type pwsObservation struct {
+	StationID         string    `json:"stationID"`
+	ObsTimeUtc        time.Time `json:"obsTimeUtc"`
+	ObsTimeLocal      string    `json:"obsTimeLocal"`
+	Neighborhood      *string   `json:"neighborhood"`
+	SoftwareType      *string   `json:"softwareType"`
+	Country           *string   `json:"country"`
+	SolarRadiation    *float64  `json:"solarRadiation"`
+	Lon               *float64  `json:"lon"`
+	Lat               *float64  `json:"lat"`
+	RealtimeFrequency *float64  `json:"realtimeFrequency"`
+	Epoch             int64     `json:"epoch"`
+	UV                *float64  `json:"uv"`
+	Winddir           *float64  `json:"winddir"`
+	Humidity          *float64  `json:"humidity"`
+	QcStatus          *int64    `json:"qcStatus"`
+
+	// Only the unit block matching the requested units (m, e, h) will be non-nil.
+	Metric   *pwsUnits `json:"metric"`
+	Imperial *pwsUnits `json:"imperial"`
+	UKHybrid *pwsUnits `json:"uk_hybrid"`
+}
+
+// pwsUnits holds the unit-dependent values of an observation.
+type pwsUnits struct {
+	Temp        *float64 `json:"temp"`
+	HeatIndex   *float64 `json:"heatIndex"`
+	Dewpt       *float64 `json:"dewpt"`
+	WindChill   *float64 `json:"windChill"`
+	WindSpeed   *float64 `json:"windSpeed"`
+	WindGust    *float64 `json:"windGust"`
+	Pressure    *float64 `json:"pressure"`
+	PrecipRate  *float64 `json:"precipRate"`
+	PrecipTotal *float64 `json:"precipTotal"`
+	Elev        *float64 `json:"elev"`
+}
+
+// unitBlocks returns the non-nil unit blocks of the observation, keyed by their JSON name.
+func (o *pwsObservation) unitBlocks() map[string]*pwsUnits {
+	blocks := map[string]*pwsUnits{}
+	if o.Metric != nil {
+		blocks["metric"] = o.Metric
+	}
+	if o.Imperial != nil {
+		blocks["imperial"] = o.Imperial
+	}
+	if o.UKHybrid != nil {
+		blocks["uk_hybrid"] = o.UKHybrid
+	}
+	return blocks
+}
+
+// time returns the time of the observation, preferring obsTimeUtc over epoch.
+// It returns the zero time if the observation has neither.
+func (o *pwsObservation) time() time.Time {
+	if !o.ObsTimeUtc.IsZero() {
+		return o.ObsTimeUtc
+	}
+	if o.Epoch != 0 {
+		return time.Unix(o.Epoch, 0)
+	}
+	return time.Time{}
+}
+
+// gauges returns the top-level scalar values of the observation, keyed by their JSON name.
+// Null values are omitted.
+// Numbers are always float64, since that is how they were written
+// back when the observation was decoded into a map; Influx will reject
+// any other type for an existing field.
+// Station metadata is not included; see metadata and tags.
+func (o *pwsObservation) gauges() map[string]interface{} {
+	g := map[string]interface{}{
+		"epoch": float64(o.Epoch),
+	}
+	addFloat(g, "solarRadiation", o.SolarRadiation)
+	addFloat(g, "uv", o.UV)
+	addFloat(g, "winddir", o.Winddir)
+	addFloat(g, "humidity", o.Humidity)
+	if o.QcStatus != nil {
+		g["qcStatus"] = float64(*o.QcStatus)
+	}
+	return g
+}
+
+// metadata returns the observation's station metadata, keyed by their JSON name.
+// Null values are omitted.
+func (o *pwsObservation) metadata() map[string]interface{} {
+	md := map[string]interface{}{
+		"obsTimeUtc":   o.ObsTimeUtc.Format(time.RFC3339),
+		"obsTimeLocal": o.ObsTimeLocal,
+	}
+	addString(md, "neighborhood", o.Neighborhood)
+	addString(md, "softwareType", o.SoftwareType)
+	addString(md, "country", o.Country)
+	addFloat(md, "lat", o.Lat)
+	addFloat(md, "lon", o.Lon)
+	addFloat(md, "realtimeFrequency", o.RealtimeFrequency)
+	return md
+}
+
+// tags returns the station metadata which describes the station's measurements,
+// and is attached to them as tags.
+// Metadata which is liable to change (eg. softwareType) is left out, since changing
+// a tag value starts a new series.
+func (o *pwsObservation) tags() map[string]string {
+	tags := map[string]string{}
+	if o.Neighborhood != nil && *o.Neighborhood != "" {
+		tags["neighborhood"] = *o.Neighborhood
+	}
+	if o.Country != nil && *o.Country != "" {
+		tags["country"] = *o.Country
+	}
+	return tags
+}
+
+// gauges returns the values of the unit block, keyed by their JSON name.
+// Null values are omitted.
+func (u *pwsUnits) gauges() map[string]interface{} {
+	g := map[string]interface{}{}
+	addFloat(g, "temp", u.Temp)
+	addFloat(g, "heatIndex", u.HeatIndex)
+	addFloat(g, "dewpt", u.Dewpt)
+	addFloat(g, "windChill", u.WindChill)
+	addFloat(g, "windSpeed", u.WindSpeed)
+	addFloat(g, "windGust", u.WindGust)
+	addFloat(g, "pressure", u.Pressure)
+	addFloat(g, "precipRate", u.PrecipRate)
+	addFloat(g, "precipTotal", u.PrecipTotal)
+	addFloat(g, "elev", u.Elev)
+	return g
+}
+
+func addFloat(m map[string]interface{}, k string, v *float64) {
+	if v != nil {
+		m[k] = *v
+	}
+}
+
+func addString(m map[string]interface{}, k string, v *string) {
+	if v != nil {
+		m[k] = *v
+	}
+}
+
+// pwsHistoryObservations is the envelope returned by the PWS history endpoints.
+type pwsHistoryObservations struct {
+	Observations []*pwsHistoryObservation `json:"observations"`
+}
+
+// pwsHistoryObservation is a summary observation as returned by
+// /v2/pws/history/all (5 minute), /hourly and /daily.
+type pwsHistoryObservation struct {
+	StationID          string    `json:"stationID"`
+	Tz                 string    `json:"tz"`
+	ObsTimeUtc         time.Time `json:"obsTimeUtc"`
+	ObsTimeLocal       string    `json:"obsTimeLocal"`
+	Epoch              int64     `json:"epoch"`
+	Lat                *float64  `json:"lat"`
+	Lon                *float64  `json:"lon"`
+	SolarRadiationHigh *float64  `json:"solarRadiationHigh"`
+	UvHigh             *float64  `json:"uvHigh"`
+	WinddirAvg         *float64  `json:"winddirAvg"`
+	HumidityHigh       *float64  `json:"humidityHigh"`
+	HumidityLow        *float64  `json:"humidityLow"`
+	HumidityAvg        *float64  `json:"humidityAvg"`
+	QcStatus           *int64    `json:"qcStatus"`
+
+	Metric   *pwsHistoryUnits `json:"metric"`
+	Imperial *pwsHistoryUnits `json:"imperial"`
+	UKHybrid *pwsHistoryUnits `json:"uk_hybrid"`
+}
+
+// pwsHistoryUnits holds the unit-dependent values of a history observation.
+type pwsHistoryUnits struct {
+	TempHigh      *float64 `json:"tempHigh"`
+	TempLow       *float64 `json:"tempLow"`
+	TempAvg       *float64 `json:"tempAvg"`
+	WindspeedHigh *float64 `json:"windspeedHigh"`
+	WindspeedLow  *float64 `json:"windspeedLow"`
+	WindspeedAvg  *float64 `json:"windspeedAvg"`
+	WindgustHigh  *float64 `json:"windgustHigh"`
+	WindgustLow   *float64 `json:"windgustLow"`
+	WindgustAvg   *float64 `json:"windgustAvg"`
+	DewptHigh     *float64 `json:"dewptHigh"`
+	DewptLow      *float64 `json:"dewptLow"`
+	DewptAvg      *float64 `json:"dewptAvg"`
+	WindchillHigh *float64 `json:"windchillHigh"`
+	WindchillLow  *float64 `json:"windchillLow"`
+	WindchillAvg  *float64 `json:"windchillAvg"`
+	HeatindexHigh *float64 `json:"heatindexHigh"`
+	HeatindexLow  *float64 `json:"heatindexLow"`
+	HeatindexAvg  *float64 `json:"heatindexAvg"`
+	PressureMax   *float64 `json:"pressureMax"`
+	PressureMin   *float64 `json:"pressureMin"`
+	PressureTrend *float64 `json:"pressureTrend"`
+	PrecipRate    *float64 `json:"precipRate"`
+	PrecipTotal   *float64 `json:"precipTotal"`
+}
+
+// unitBlocks returns the non-nil unit blocks of the observation, keyed by their JSON name.
+func (o *pwsHistoryObservation) unitBlocks() map[string]*pwsHistoryUnits {
+	blocks := map[string]*pwsHistoryUnits{}
+	if o.Metric != nil {
+		blocks["metric"] = o.Metric
+	}
+	if o.Imperial != nil {
+		blocks["imperial"] = o.Imperial
+	}
+	if o.UKHybrid != nil {
+		blocks["uk_hybrid"] = o.UKHybrid
+	}
+	return blocks
+}
+
+// gauges returns the top-level scalar values of the observation, keyed by their JSON name.
+// Null values are omitted.
+func (o *pwsHistoryObservation) gauges() map[string]interface{} {
+	g := map[string]interface{}{
+		"epoch": float64(o.Epoch),
+	}
+	addFloat(g, "solarRadiationHigh", o.SolarRadiationHigh)
+	addFloat(g, "uvHigh", o.UvHigh)
+	addFloat(g, "winddirAvg", o.WinddirAvg)
+	addFloat(g, "humidityHigh", o.HumidityHigh)
+	addFloat(g, "humidityLow", o.HumidityLow)
+	addFloat(g, "humidityAvg", o.HumidityAvg)
+	if o.QcStatus != nil {
+		g["qcStatus"] = float64(*o.QcStatus)
+	}
+	return g
+}
+
+// gauges returns the values of the unit block, keyed by their JSON name.
+// Null values are omitted.
+func (u *pwsHistoryUnits) gauges() map[string]interface{} {
+	g := map[string]interface{}{}
+	addFloat(g, "tempHigh", u.TempHigh)
+	addFloat(g, "tempLow", u.TempLow)
+	addFloat(g, "tempAvg", u.TempAvg)
+	addFloat(g, "windspeedHigh", u.WindspeedHigh)
+	addFloat(g, "windspeedLow", u.WindspeedLow)
+	addFloat(g, "windspeedAvg", u.WindspeedAvg)
+	addFloat(g, "windgustHigh", u.WindgustHigh)
+	addFloat(g, "windgustLow", u.WindgustLow)
+	addFloat(g, "windgustAvg", u.WindgustAvg)
+	addFloat(g, "dewptHigh", u.DewptHigh)
+	addFloat(g, "dewptLow", u.DewptLow)
+	addFloat(g, "dewptAvg", u.DewptAvg)
+	addFloat(g, "windchillHigh", u.WindchillHigh)
+	addFloat(g, "windchillLow", u.WindchillLow)
+	addFloat(g, "windchillAvg", u.WindchillAvg)
+	addFloat(g, "heatindexHigh", u.HeatindexHigh)
+	addFloat(g, "heatindexLow", u.HeatindexLow)
+	addFloat(g, "heatindexAvg", u.HeatindexAvg)
+	addFloat(g, "pressureMax", u.PressureMax)
+	addFloat(g, "pressureMin", u.PressureMin)
+	addFloat(g, "pressureTrend", u.PressureTrend)
+	addFloat(g, "precipRate", u.PrecipRate)
+	addFloat(g, "precipTotal", u.PrecipTotal)
+	return g
+}
+
+// observation approximates a current-conditions observation from the summary,
+// using the averages where the summary has them.
+// This lets 5 minute history fill the same series as the current-conditions poller.
+func (o *pwsHistoryObservation) observation() *pwsObservation {
+	obs := &pwsObservation{
+		StationID:      o.StationID,
+		ObsTimeUtc:     o.ObsTimeUtc,
+		ObsTimeLocal:   o.ObsTimeLocal,
+		Epoch:          o.Epoch,
+		Lat:            o.Lat,
+		Lon:            o.Lon,
+		SolarRadiation: o.SolarRadiationHigh,
+		UV:             o.UvHigh,
+		Winddir:        o.WinddirAvg,
+		Humidity:       o.HumidityAvg,
+		QcStatus:       o.QcStatus,
+	}
+	if o.Metric != nil {
+		obs.Metric = o.Metric.units()
+	}
+	if o.Imperial != nil {
+		obs.Imperial = o.Imperial.units()
+	}
+	if o.UKHybrid != nil {
+		obs.UKHybrid = o.UKHybrid.units()
+	}
+	return obs
+}
+
+func (u *pwsHistoryUnits) units() *pwsUnits {
+	pressure := u.PressureMax
+	if u.PressureMax != nil && u.PressureMin != nil {
+		avg := (*u.PressureMax + *u.PressureMin) / 2
+		pressure = &avg
+	}
+	return &pwsUnits{
+		Temp:        u.TempAvg,
+		HeatIndex:   u.HeatindexAvg,
+		Dewpt:       u.DewptAvg,
+		WindChill:   u.WindchillAvg,
+		WindSpeed:   u.WindspeedAvg,
+		WindGust:    u.WindgustHigh,
+		Pressure:    pressure,
+		PrecipRate:  u.PrecipRate,
+		PrecipTotal: u.PrecipTotal,
+	}
+}
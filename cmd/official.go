@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// officialObservation is the official (non-PWS) current conditions,
+// as returned by /v3/wx/observations/current.
+// Fields the API may send as null are pointers.
+type officialObservation struct {
+	ValidTimeUtc      int64 `json:"validTimeUtc"`
+	ExpirationTimeUtc int64 `json:"expirationTimeUtc"`
+
+	Temperature           *float64 `json:"temperature"`
+	TemperatureDewPoint   *float64 `json:"temperatureDewPoint"`
+	TemperatureFeelsLike  *float64 `json:"temperatureFeelsLike"`
+	TemperatureHeatIndex  *float64 `json:"temperatureHeatIndex"`
+	TemperatureWindChill  *float64 `json:"temperatureWindChill"`
+	RelativeHumidity      *float64 `json:"relativeHumidity"`
+	PressureAltimeter     *float64 `json:"pressureAltimeter"`
+	PressureMeanSeaLevel  *float64 `json:"pressureMeanSeaLevel"`
+	PressureChange        *float64 `json:"pressureChange"`
+	WindSpeed             *float64 `json:"windSpeed"`
+	WindGust              *float64 `json:"windGust"`
+	WindDirection         *float64 `json:"windDirection"`
+	Precip1Hour           *float64 `json:"precip1Hour"`
+	Precip6Hour           *float64 `json:"precip6Hour"`
+	Precip24Hour          *float64 `json:"precip24Hour"`
+	Snow1Hour             *float64 `json:"snow1Hour"`
+	Snow6Hour             *float64 `json:"snow6Hour"`
+	Snow24Hour            *float64 `json:"snow24Hour"`
+	UVIndex               *float64 `json:"uvIndex"`
+	Visibility            *float64 `json:"visibility"`
+	CloudCeiling          *float64 `json:"cloudCeiling"`
+	WxPhraseLong          *string  `json:"wxPhraseLong"`
+	CloudCoverPhrase      *string  `json:"cloudCoverPhrase"`
+	TemperatureMax24Hour  *float64 `json:"temperatureMax24Hour"`
+	TemperatureMin24Hour  *float64 `json:"temperatureMin24Hour"`
+	PressureTendencyCode  *float64 `json:"pressureTendencyCode"`
+	PressureTendencyTrend *string  `json:"pressureTendencyTrend"`
+}
+
+// officialDefaultExpiry is used when the observation has no expiry, or it has passed.
+// Official observations are typically updated every 10 minutes.
+const officialDefaultExpiry = 10 * time.Minute
+
+// expiry returns when the official observation should next be requested.
+func (o *officialObservation) expiry(now time.Time) time.Time {
+	if t := time.Unix(o.ExpirationTimeUtc, 0); o.ExpirationTimeUtc > 0 && t.After(now) {
+		return t
+	}
+	return now.Add(officialDefaultExpiry)
+}
+
+// fields returns the non-null values of the observation, keyed by their JSON name.
+func (o *officialObservation) fields() map[string]interface{} {
+	f := map[string]interface{}{}
+	addFloat(f, "temperature", o.Temperature)
+	addFloat(f, "temperatureDewPoint", o.TemperatureDewPoint)
+	addFloat(f, "temperatureFeelsLike", o.TemperatureFeelsLike)
+	addFloat(f, "temperatureHeatIndex", o.TemperatureHeatIndex)
+	addFloat(f, "temperatureWindChill", o.TemperatureWindChill)
+	addFloat(f, "temperatureMax24Hour", o.TemperatureMax24Hour)
+	addFloat(f, "temperatureMin24Hour", o.TemperatureMin24Hour)
+	addFloat(f, "relativeHumidity", o.RelativeHumidity)
+	addFloat(f, "pressureAltimeter", o.PressureAltimeter)
+	addFloat(f, "pressureMeanSeaLevel", o.PressureMeanSeaLevel)
+	addFloat(f, "pressureChange", o.PressureChange)
+	addFloat(f, "pressureTendencyCode", o.PressureTendencyCode)
+	addFloat(f, "windSpeed", o.WindSpeed)
+	addFloat(f, "windGust", o.WindGust)
+	addFloat(f, "windDirection", o.WindDirection)
+	addFloat(f, "precip1Hour", o.Precip1Hour)
+	addFloat(f, "precip6Hour", o.Precip6Hour)
+	addFloat(f, "precip24Hour", o.Precip24Hour)
+	addFloat(f, "snow1Hour", o.Snow1Hour)
+	addFloat(f, "snow6Hour", o.Snow6Hour)
+	addFloat(f, "snow24Hour", o.Snow24Hour)
+	addFloat(f, "uvIndex", o.UVIndex)
+	addFloat(f, "visibility", o.Visibility)
+	addFloat(f, "cloudCeiling", o.CloudCeiling)
+	addString(f, "wxPhraseLong", o.WxPhraseLong)
+	addString(f, "cloudCoverPhrase", o.CloudCoverPhrase)
+	addString(f, "pressureTendencyTrend", o.PressureTendencyTrend)
+	return f
+}
+
+func (m *managerWU) requestOfficialCurrent(location forecastLocation) (res *officialObservation, err error) {
+	payload := url.Values{}
+	payload.Add(location.key, location.value)
+	payload.Add("format", "json")
+	payload.Add("units", "m")
+	payload.Add("language", "en-US")
+	dataBytes, err := m.get("/v3/wx/observations/current", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the response body.
+	data := &officialObservation{}
+	err = json.Unmarshal(dataBytes, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// recordOfficialObs writes the official observation as a single point at its valid time,
+// tagged with source=official and the given tags (if any).
+func (m *managerInflux) recordOfficialObs(obs *officialObservation, extraTags map[string]string) error {
+	measurementName := fmt.Sprintf("%s/observations/current", m.namespace)
+	tags := map[string]string{
+		"source": "official",
+	}
+	for k, v := range extraTags {
+		tags[k] = v
+	}
+	fields := obs.fields()
+	if len(fields) == 0 {
+		return fmt.Errorf("official observation has no values")
+	}
+
+	p := influxdb2.NewPoint(measurementName, tags, fields, time.Unix(obs.ValidTimeUtc, 0))
+	if err := m.clientAPI.WritePoint(context.Background(), p); err != nil {
+		log.Error("Write point", "error", err)
+		return err
+	}
+	log.Debug("Wrote point", "type", "official", measurementName, len(fields))
+	return nil
+}
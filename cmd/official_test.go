@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOfficialObservation(t *testing.T) {
+	var tries int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The timed out request is still sleeping when the retry arrives.
+		if atomic.AddInt32(&tries, 1) == 1 {
+			// Time out the first request; it should be retried.
+			time.Sleep(200 * time.Millisecond)
+			return
+		}
+		if got := r.URL.Query().Get("postalKey"); got != "99129:US" {
+			t.Errorf("postalKey: got %q", got)
+		}
+		w.Write([]byte(`{"validTimeUtc":1637081000,"expirationTimeUtc":1637081600,"temperature":4,"windGust":null,"wxPhraseLong":"Partly Cloudy"}`))
+	}))
+	defer srv.Close()
+
+	m := &managerWU{
+		client:        srv.Client(),
+		baseURL:       srv.URL,
+		timeout:       100 * time.Millisecond,
+		retryPolicies: map[string]retryPolicy{errClassNetwork: {tries: 3}},
+	}
+	obs, err := m.requestOfficialCurrent(forecastLocation{key: "postalKey", value: "99129:US"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&tries); n != 2 {
+		t.Errorf("want 2 tries, got %d", n)
+	}
+
+	api := &fakeWriteAPI{}
+	mi := &managerInflux{clientAPI: api, namespace: "wu2."}
+	if err := mi.recordOfficialObs(obs, map[string]string{"stationID": "KWAFRUIT1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.points) != 1 {
+		t.Fatalf("want 1 point, got %d", len(api.points))
+	}
+	pt := api.points[0]
+	if !pt.Time().Equal(time.Unix(1637081000, 0)) {
+		t.Errorf("want point at valid time, got %v", pt.Time())
+	}
+	if len(pt.FieldList()) != 2 {
+		t.Errorf("want temperature and wxPhraseLong fields, got %v", pt.FieldList())
+	}
+	tags := map[string]string{}
+	for _, tag := range pt.TagList() {
+		tags[tag.Key] = tag.Value
+	}
+	if tags["source"] != "official" || tags["stationID"] != "KWAFRUIT1" {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+}
+
+func TestOfficialObservationExpiry(t *testing.T) {
+	now := time.Unix(1637081000, 0)
+	for _, tc := range []struct {
+		expiration int64
+		want       time.Time
+	}{
+		{1637081600, time.Unix(1637081600, 0)},
+		{0, now.Add(officialDefaultExpiry)},
+		{1637080000, now.Add(officialDefaultExpiry)},
+	} {
+		obs := &officialObservation{ExpirationTimeUtc: tc.expiration}
+		if got := obs.expiry(now); !got.Equal(tc.want) {
+			t.Errorf("expiration %d: want %v, got %v", tc.expiration, tc.want, got)
+		}
+	}
+}
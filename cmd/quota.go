@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+)
+
+var errQuotaExhausted = errors.New("daily API call quota exhausted")
+
+// callBudget counts API calls per key per (UTC) day, persisted in the datadir,
+// and enforces the daily and per-minute call limits of the keys.
+// A limit of 0 is unlimited.
+type callBudget struct {
+	perDay    int
+	perMinute int
+
+	mu     sync.Mutex
+	day    string
+	calls  map[string]int
+	recent map[string][]time.Time
+}
+
+// quotaStore is the persisted state of a callBudget.
+type quotaStore struct {
+	Day   string         `json:"day"`
+	Calls map[string]int `json:"calls"`
+}
+
+func newCallBudget(perDay, perMinute int) *callBudget {
+	b := &callBudget{
+		perDay:    perDay,
+		perMinute: perMinute,
+		calls:     map[string]int{},
+		recent:    map[string][]time.Time{},
+	}
+	store := getQuotaStore()
+	if store != nil {
+		b.day = store.Day
+		b.calls = store.Calls
+	}
+	return b
+}
+
+// keyID identifies an API key in logs, metrics and the datadir without revealing it.
+func keyID(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+func quotaDay(t time.Time) string {
+	return t.UTC().Format("20060102")
+}
+
+// rollover resets the call counts at the start of a new day.
+// It must be called with the lock held.
+func (b *callBudget) rollover(now time.Time) {
+	if day := quotaDay(now); day != b.day {
+		b.day = day
+		b.calls = map[string]int{}
+	}
+}
+
+// take accounts for a call with the key.
+// It waits for the per-minute limit, unless stop is closed first (errStopped),
+// and returns errQuotaExhausted if the key has no calls left today.
+func (b *callBudget) take(apiKey string, stop <-chan struct{}) error {
+	id := keyID(apiKey)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.rollover(now)
+	if b.perDay > 0 && b.calls[id] >= b.perDay {
+		return errQuotaExhausted
+	}
+
+	if b.perMinute > 0 {
+		var recent []time.Time
+		for _, t := range b.recent[id] {
+			if now.Sub(t) < time.Minute {
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) >= b.perMinute {
+			wait := time.Minute - now.Sub(recent[0])
+			log.Debug("Waiting for per-minute API quota", "key", id, "wait", wait.Round(time.Millisecond))
+			if !sleepUntilStopped(stop, wait) {
+				return errStopped
+			}
+			now = time.Now()
+			recent = recent[1:]
+		}
+		b.recent[id] = append(recent, now)
+	}
+
+	b.calls[id]++
+	saveQuotaStore(&quotaStore{Day: b.day, Calls: b.calls})
+	return nil
+}
+
+// remaining returns the number of calls the key has left today, or -1 if unlimited.
+func (b *callBudget) remaining(apiKey string) int {
+	if b.perDay <= 0 {
+		return -1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(time.Now())
+	return b.perDay - b.calls[keyID(apiKey)]
+}
+
+// estimateDailyCalls estimates the API calls per day that the run configuration will make.
+// The estimate is broken down by collector.
+func estimateDailyCalls(rc *runConfig) map[string]int {
+	day := 24 * time.Hour
+	estimate := map[string]int{}
+	if rc.interval > 0 {
+		estimate["observations"] = len(rc.stations) * int(day/rc.interval)
+	} else {
+		estimate["observations"] = len(rc.stations)
+	}
+
+	targets := len(rc.forecastTargets)
+	if flagAppForecastPerStation {
+		targets = len(rc.stations)
+	}
+	// Forecasts, official observations and air quality are requested when they expire,
+	// which is typically hourly, every 10 minutes and hourly respectively.
+	if flagAppForecastEnable {
+		estimate["forecast"] = targets * 24
+	}
+	if flagAppForecastHourly != "" {
+		estimate["forecastHourly"] = targets * 24
+	}
+	if flagAppOfficialEnable {
+		estimate["official"] = targets * 144
+	}
+	if flagAppAirQualityEnable {
+		estimate["airQuality"] = targets * 24
+	}
+	if flagAppAlertsEnable && flagAppAlertsInterval > 0 {
+		estimate["alerts"] = targets * int(day/flagAppAlertsInterval)
+	}
+	return estimate
+}
+
+// checkBudget returns an error if the run configuration would exceed the budget.
+func (b *callBudget) checkBudget(rc *runConfig, keys int) error {
+	if keys < 1 {
+		keys = 1
+	}
+	if b.perDay > 0 {
+		total := 0
+		estimate := estimateDailyCalls(rc)
+		for _, n := range estimate {
+			total += n
+		}
+		if total > b.perDay*keys {
+			return fmt.Errorf("configuration needs about %d API calls per day %v, but the quota is %d per day per key (keys=%d); raise app_interval or poll fewer stations",
+				total, estimate, b.perDay, keys)
+		}
+	}
+	if b.perMinute > 0 && rc.interval > 0 {
+		// Station polls are spread evenly over the interval.
+		perMinute := float64(len(rc.stations)) / rc.interval.Minutes()
+		if perMinute > float64(b.perMinute*keys) {
+			return fmt.Errorf("configuration needs %.1f API calls per minute, but the quota is %d per minute per key (keys=%d)",
+				perMinute, b.perMinute, keys)
+		}
+	}
+	return nil
+}
+
+func quotaStorePath() string {
+	return filepath.Join(flagAppDatadir, "quota")
+}
+
+func getQuotaStore() *quotaStore {
+	os.MkdirAll(flagAppDatadir, os.ModePerm)
+	data, err := ioutil.ReadFile(quotaStorePath())
+	if err != nil {
+		return nil
+	}
+	v := &quotaStore{}
+	err = json.Unmarshal(data, v)
+	if err != nil || v.Calls == nil {
+		return nil
+	}
+	return v
+}
+
+func saveQuotaStore(store *quotaStore) {
+	os.MkdirAll(flagAppDatadir, os.ModePerm)
+	data, err := json.Marshal(store)
+	if err != nil {
+		log.Error("Failed to marshal JSON quota store", "error", err)
+		return
+	}
+	err = ioutil.WriteFile(quotaStorePath(), data, os.ModePerm)
+	if err != nil {
+		log.Error("Failed to save quota store", "error", err)
+	}
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallBudgetDaily(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+
+	b := newCallBudget(3, 0)
+	for i := 0; i < 3; i++ {
+		if err := b.take("key1", nil); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+	if err := b.take("key1", nil); err != errQuotaExhausted {
+		t.Fatalf("want quota exhausted, got %v", err)
+	}
+	if err := b.take("key2", nil); err != nil {
+		t.Fatalf("want keys counted separately, got %v", err)
+	}
+
+	// The counts are persisted.
+	b = newCallBudget(5, 0)
+	if got := b.remaining("key1"); got != 2 {
+		t.Errorf("want 2 remaining, got %d", got)
+	}
+}
+
+func TestCallBudgetPerMinuteStops(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+
+	b := newCallBudget(0, 1)
+	if err := b.take("key1", nil); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	close(stop)
+	start := time.Now()
+	if err := b.take("key1", stop); err != errStopped {
+		t.Errorf("want the wait stopped, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want the wait cut short, took %v", elapsed)
+	}
+}
+
+func TestCallBudgetCheck(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+
+	rc := &runConfig{
+		stations: []string{"KWAFRUIT1", "KWAHUNTE3"},
+		interval: 32 * time.Second,
+	}
+	if err := newCallBudget(1500, 30).checkBudget(rc, 1); err == nil {
+		t.Error("want 2 stations every 32s to exceed 1500 calls per day")
+	}
+	rc.interval = 5 * time.Minute
+	if err := newCallBudget(1500, 30).checkBudget(rc, 1); err != nil {
+		t.Errorf("want 2 stations every 5m to fit, got %v", err)
+	}
+	if err := newCallBudget(0, 0).checkBudget(rc, 1); err != nil {
+		t.Errorf("want unlimited budget to fit, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/url"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+)
+
+// pwsReportingPeriod is how often a typical station uploads an observation.
+// An observation up to this old is not considered a gap.
+const pwsReportingPeriod = 5 * time.Minute
+
+// lastObservationTime returns the time of the station's most recently written observation,
+// looking back no further than the given window.
+// It returns the zero time if there is none.
+func (m *managerInflux) lastObservationTime(station string, window time.Duration) (time.Time, error) {
+	// The epoch holds the observation's own time, regardless of the point's time.
+	var epoch float64
+	var ok bool
+	var err error
+	if m.writesSchema(schemaV2) {
+		epoch, ok, err = m.lastGaugeValue(m.namespace+"epoch.gauge", station, window)
+	} else {
+		epoch, ok, err = m.lastFieldValue(schemaV3Measurement, "epoch", station, window)
+	}
+	if err != nil || !ok {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(epoch), 0), nil
+}
+
+// recoverGaps fills the gap between each station's last written observation and now,
+// using /v2/pws/observations/all/1day for the last 24 hours, and the history
+// endpoint for any days before that.
+func recoverGaps(rc *runConfig) {
+	for _, station := range rc.stations {
+		rc.managerInflux.currentStation = station
+
+		last, err := rc.managerInflux.lastObservationTime(station, rc.recoverMax)
+		if err != nil {
+			log.Error("Query last observation time", "station", station, "error", err)
+			continue
+		}
+		if last.IsZero() {
+			log.Warn("No recent observations to recover from; use the backfill command", "station", station, "window", rc.recoverMax)
+			continue
+		}
+		gap := time.Since(last)
+		if gap <= rc.interval+pwsReportingPeriod {
+			continue
+		}
+		log.Info("Recovering observation gap", "station", station, "last", last, "gap", gap.Round(time.Second))
+
+		var observations []*pwsHistoryObservation
+		cutoff := time.Now().Add(-24 * time.Hour)
+		if last.Before(cutoff) {
+			// History dates are the station's local date, so pad the range by a day either side.
+			// Overlapping observations are harmless; Influx overwrites identical points.
+			first := last.UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+			final := cutoff.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+			for day := first; !day.After(final); day = day.AddDate(0, 0, 1) {
+				res, err := rc.manWU.requestHistory(station, "all", day)
+				if err != nil {
+					log.Error("Recovery history request failed", "station", station, "date", day.Format("20060102"), "error", err)
+					continue
+				}
+				observations = append(observations, res.Observations...)
+			}
+		}
+		res, err := rc.manWU.requestRecent(station)
+		if err != nil {
+			log.Error("Recovery request failed", "station", station, "error", err)
+		} else {
+			observations = append(observations, res.Observations...)
+		}
+
+		recovered := 0
+		for _, obs := range observations {
+			if obs == nil || !obs.ObsTimeUtc.After(last) {
+				continue
+			}
+			if err := rc.managerInflux.recordHistoryObs("all", obs); err != nil {
+				log.Error("Post InfluxDB recovered observation", "error", err)
+				continue
+			}
+			recovered++
+		}
+		log.Info("Recovered observation gap", "station", station, "observations", recovered)
+	}
+}
+
+// requestRecent requests the station's observations (5 minute summaries) for the last 24 hours.
+func (m *managerWU) requestRecent(station string) (res *pwsHistoryObservations, err error) {
+	payload := url.Values{}
+	payload.Add("stationId", station)
+	payload.Add("format", "json")
+	payload.Add("units", "m")
+	dataBytes, err := m.get("/v2/pws/observations/all/1day", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the response body.
+	data := &pwsHistoryObservations{}
+	if len(dataBytes) == 0 {
+		return data, nil
+	}
+	err = json.Unmarshal(dataBytes, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
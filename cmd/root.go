@@ -23,6 +23,7 @@ import (
 
   homedir "github.com/mitchellh/go-homedir"
   "github.com/spf13/cobra"
+  "github.com/spf13/pflag"
   "github.com/spf13/viper"
 )
 
@@ -97,11 +98,22 @@ func initConfig() {
     fmt.Println("Using config file:", viper.ConfigFileUsed())
   }
 
-  viper.BindPFlags(ETLCmd.PersistentFlags())
+  // Bind the shared (root) flags, and those of every subcommand.
+  flagSets := []*pflag.FlagSet{rootCmd.PersistentFlags()}
+  for _, c := range rootCmd.Commands() {
+    flagSets = append(flagSets, c.PersistentFlags())
+  }
+  for _, fs := range flagSets {
+    viper.BindPFlags(fs)
+  }
 
   for k, v := range viper.AllSettings() {
     if vs, ok := v.(string); ok {
-      ETLCmd.PersistentFlags().Set(k, vs)
+      for _, fs := range flagSets {
+        if fs.Lookup(k) != nil {
+          fs.Set(k, vs)
+        }
+      }
     }
   }
 }
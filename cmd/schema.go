@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// Schemas in which observations are written.
+// Several may be written at once, eg. while dashboards move from one to the other.
+const (
+	// schemaV2 is a measurement per value, eg. wu2.metric.temp.gauge, with a single value field.
+	schemaV2 = "v2"
+
+	// schemaV3 is a row per observation and units: the observation measurement,
+	// tagged with the stationID and units, with a field per value, eg. temp.
+	schemaV3 = "v3"
+)
+
+var influxSchemas = []string{schemaV2, schemaV3}
+
+// schemaV3Measurement is the measurement of schema v3 observations.
+const schemaV3Measurement = "observation"
+
+func isInfluxSchema(schema string) bool {
+	for _, s := range influxSchemas {
+		if s == schema {
+			return true
+		}
+	}
+	return false
+}
+
+// writesSchema returns true if observations are written in the schema.
+// Without configured schemas, only v2 is written.
+func (m *managerInflux) writesSchema(schema string) bool {
+	if len(m.schemas) == 0 {
+		return schema == schemaV2
+	}
+	for _, s := range m.schemas {
+		if s == schema {
+			return true
+		}
+	}
+	return false
+}
+
+// postObservation writes an observation in each of the configured schemas.
+// The gauges are the values which don't depend on units,
+// and the blocks are the others, keyed by their units (eg. metric).
+func (m *managerInflux) postObservation(gauges map[string]interface{}, blocks map[string]map[string]interface{}, tags map[string]string, now time.Time) {
+	if m.writesSchema(schemaV2) {
+		m.postGauges("", gauges, tags, now)
+		for annotation, g := range blocks {
+			m.postGauges(annotation, g, tags, now)
+		}
+	}
+	if m.writesSchema(schemaV3) {
+		m.postObservationRows(gauges, blocks, tags, now)
+	}
+}
+
+// postObservationRows writes the observation in schema v3: a row for each units block,
+// with both the block's values and the values which don't depend on units,
+// so that any row is a whole observation.
+// An observation without units blocks is written as a single row without a units tag.
+func (m *managerInflux) postObservationRows(gauges map[string]interface{}, blocks map[string]map[string]interface{}, extraTags map[string]string, now time.Time) {
+	if len(blocks) == 0 {
+		blocks = map[string]map[string]interface{}{"": {}}
+	}
+	for units, block := range blocks {
+		tags := map[string]string{
+			"stationID": m.currentStation,
+		}
+		if units != "" {
+			tags["units"] = units
+		}
+		for k, v := range extraTags {
+			tags[k] = v
+		}
+		fields := map[string]interface{}{}
+		for k, v := range gauges {
+			if value, ok := m.fieldValue(k, v); ok {
+				fields[m.fieldKey(schemaV3Measurement, k, value)] = value
+			}
+		}
+		for k, v := range block {
+			path := k
+			if units != "" {
+				path = units + "." + k
+			}
+			if value, ok := m.fieldValue(path, v); ok {
+				fields[m.fieldKey(schemaV3Measurement, k, value)] = value
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		p := influxdb2.NewPoint(schemaV3Measurement, tags, fields, now)
+		if err := m.clientAPI.WritePoint(context.Background(), p); err != nil {
+			log.Error("Write point", "error", err)
+			continue
+		}
+		log.Debug("Wrote point", "station", m.currentStation, "units", units, schemaV3Measurement, len(fields))
+	}
+}
+
+// parseInfluxSchemas validates the configured schemas.
+func parseInfluxSchemas(schemas []string) ([]string, error) {
+	if len(schemas) == 0 {
+		return nil, fmt.Errorf("no schema, want at least one of %v", influxSchemas)
+	}
+	for _, s := range schemas {
+		if !isInfluxSchema(s) {
+			return nil, fmt.Errorf("invalid schema %q, want %v", s, influxSchemas)
+		}
+	}
+	return schemas, nil
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordObsSchemas(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("..", "example-kwafruit1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &weatherUndergroundObservations{}
+	if err := json.Unmarshal(b, data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		schemas    []string
+		wantGauges bool
+		wantRows   bool
+	}{
+		{nil, true, false},
+		{[]string{schemaV2}, true, false},
+		{[]string{schemaV3}, false, true},
+		{[]string{schemaV2, schemaV3}, true, true},
+	} {
+		flagAppDatadir = t.TempDir()
+		api := &fakeWriteAPI{}
+		m := &managerInflux{
+			currentStation: "KWAFRUIT1",
+			clientAPI:      api,
+			namespace:      "wu2.",
+			schemas:        tc.schemas,
+		}
+		if err := m.recordObs(data.Observations[0], time.Unix(1634660520, 0)); err != nil {
+			t.Fatal(err)
+		}
+
+		gauges, rows := 0, 0
+		for _, pt := range api.points {
+			switch {
+			case strings.HasSuffix(pt.Name(), ".gauge"):
+				gauges++
+			case pt.Name() == schemaV3Measurement:
+				rows++
+				tags := map[string]string{}
+				for _, tag := range pt.TagList() {
+					tags[tag.Key] = tag.Value
+				}
+				if tags["stationID"] != "KWAFRUIT1" || tags["units"] != "metric" {
+					t.Errorf("%v: unexpected tags: %v", tc.schemas, tags)
+				}
+				fields := map[string]interface{}{}
+				for _, f := range pt.FieldList() {
+					fields[f.Key] = f.Value
+				}
+				for _, k := range []string{"temp", "dewpt", "pressure", "humidity", "epoch", "ingestLag", "precipCumulative"} {
+					if _, ok := fields[k].(float64); !ok {
+						t.Errorf("%v: want float field %s, got %#v", tc.schemas, k, fields[k])
+					}
+				}
+			}
+		}
+		if (gauges > 0) != tc.wantGauges {
+			t.Errorf("%v: got %d gauges", tc.schemas, gauges)
+		}
+		if wantRows := map[bool]int{true: 1}[tc.wantRows]; rows != wantRows {
+			t.Errorf("%v: want %d observation rows, got %d", tc.schemas, wantRows, rows)
+		}
+	}
+}
+
+func TestLastObservationTimeV3(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if q := r.FormValue("q"); !strings.Contains(q, `last("epoch") FROM "observation"`) {
+			t.Errorf("unexpected query: %s", q)
+		}
+		w.Write([]byte(`{"results":[{"statement_id":0,"series":[{"name":"observation","columns":["time","last"],"values":[[1634660431,1634660430]]}]}]}`))
+	}))
+	defer srv.Close()
+
+	m := &managerInflux{
+		endpoint:  srv.URL,
+		token:     "eve:secret",
+		bucket:    "dbriverrun/autogen",
+		namespace: "wu2.",
+		schemas:   []string{schemaV3},
+	}
+	last, err := m.lastObservationTime("KWAFRUIT1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.Equal(time.Unix(1634660430, 0)) {
+		t.Errorf("got %v", last)
+	}
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/ethereum/go-ethereum/log"
+)
+
+// stationState is the per-station state which is persisted in the datadir.
+type stationState struct {
+	// LastEpoch is the epoch of the last observation written.
+	LastEpoch int64 `json:"lastEpoch"`
+
+	// Skipped counts observations which were skipped because they had already been written.
+	Skipped int64 `json:"skipped"`
+
+	// Offline is true once the station has been reported offline,
+	// since OfflineSince (unix seconds).
+	Offline      bool  `json:"offline"`
+	OfflineSince int64 `json:"offlineSince,omitempty"`
+
+	// Tags are the tags of the last observation written (see pwsObservation.tags),
+	// so that history observations, which don't have them, join the same series.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+func stationStatePath(station string) string {
+	return filepath.Join(flagAppDatadir, "station-"+station)
+}
+
+func getStationState(station string) *stationState {
+	v := &stationState{}
+	os.MkdirAll(flagAppDatadir, os.ModePerm)
+	data, err := ioutil.ReadFile(stationStatePath(station))
+	if err != nil {
+		return v
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		log.Warn("Ignoring unreadable station state", "station", station, "error", err)
+		return &stationState{}
+	}
+	return v
+}
+
+func saveStationState(station string, state *stationState) {
+	os.MkdirAll(flagAppDatadir, os.ModePerm)
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.Error("Failed to marshal JSON station state", "error", err)
+		return
+	}
+	err = ioutil.WriteFile(stationStatePath(station), data, os.ModePerm)
+	if err != nil {
+		log.Error("Failed to save station state", "station", station, "error", err)
+	}
+}
+
+// recordNewObs writes the observation, unless it has already been written:
+// stations typically report less often than we poll, so the same observation comes back.
+// It returns false if the observation was skipped. The state is saved either way.
+func (m *managerInflux) recordNewObs(station string, state *stationState, obs *pwsObservation, polled time.Time) (bool, error) {
+	if obs != nil && !obs.time().IsZero() && obs.time().Unix() <= state.LastEpoch {
+		state.Skipped++
+		saveStationState(station, state)
+		m.postAppGauge("skippedObservations", float64(state.Skipped))
+		log.Debug("Skipped unchanged observation", "station", station, "epoch", state.LastEpoch, "skipped", state.Skipped)
+		return false, nil
+	}
+	if err := m.recordObs(obs, polled); err != nil {
+		return false, err
+	}
+	if !obs.time().IsZero() {
+		state.LastEpoch = obs.time().Unix()
+	}
+	state.Tags = obs.tags()
+	saveStationState(station, state)
+	return true, nil
+}
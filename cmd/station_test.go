@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordNewObsSkipsWrittenObservations(t *testing.T) {
+	flagAppDatadir = t.TempDir()
+
+	b, err := ioutil.ReadFile(filepath.Join("..", "example-kwafruit1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &weatherUndergroundObservations{}
+	if err := json.Unmarshal(b, data); err != nil {
+		t.Fatal(err)
+	}
+	obs := data.Observations[0]
+
+	api := &fakeWriteAPI{}
+	m := &managerInflux{currentStation: "KWAFRUIT1", clientAPI: api, namespace: "wu2."}
+
+	// The same observation is polled twice.
+	var written []bool
+	for i := 0; i < 2; i++ {
+		state := getStationState("KWAFRUIT1")
+		ok, err := m.recordNewObs("KWAFRUIT1", state, obs, obs.time().Add(time.Duration(i+1)*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		written = append(written, ok)
+	}
+	if !written[0] || written[1] {
+		t.Errorf("want the first written and the second skipped, got %v", written)
+	}
+
+	epochs, skipped := 0, 0
+	for _, pt := range api.points {
+		switch pt.Name() {
+		case "wu2.epoch.gauge":
+			epochs++
+		case "wu2.app.skippedObservations.gauge":
+			skipped++
+			if v := pt.FieldList()[0].Value; v != 1.0 {
+				t.Errorf("skippedObservations: got %v", v)
+			}
+		}
+	}
+	if epochs != 1 || skipped != 1 {
+		t.Errorf("want 1 observation written and 1 skipped, got %d and %d", epochs, skipped)
+	}
+
+	state := getStationState("KWAFRUIT1")
+	if state.LastEpoch != obs.time().Unix() || state.Skipped != 1 {
+		t.Errorf("want state persisted, got %+v", state)
+	}
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/ethereum/go-ethereum/log"
+	"github.com/spf13/cobra"
+)
+
+// stationsCmd represents the stations command
+var stationsCmd = &cobra.Command{
+	Use:   "stations",
+	Short: "Find Personal Weather Stations",
+}
+
+// stationsNearCmd represents the stations near command
+var stationsNearCmd = &cobra.Command{
+	Use:   "near",
+	Short: "List the Personal Weather Stations near a location",
+	Long: `List the Personal Weather Stations near a geocode (--stations_geocode),
+within the given radius, nearest first.
+
+With --stations_format=wu_stations, a ready-to-paste wu_stations setting is printed instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		setupLogging()
+
+		location := forecastLocation{key: "geocode", value: flagStationsGeocode}
+		if err := location.validate(); err != nil {
+			log.Crit("Invalid stations geocode", "error", err)
+		}
+		if flagStationsFormat != "table" && flagStationsFormat != "wu_stations" {
+			log.Crit("Invalid stations format", "format", flagStationsFormat, "valid", []string{"table", "wu_stations"})
+		}
+
+		res, err := newManagerWU().requestStationsNear(location)
+		if err != nil {
+			log.Crit("Stations near request failed", "error", err)
+		}
+		stations := res.within(flagStationsRadius)
+
+		if flagStationsFormat == "wu_stations" {
+			printWUStations(cmd.OutOrStdout(), stations)
+			return
+		}
+		printStationsTable(cmd.OutOrStdout(), stations)
+	},
+}
+
+var flagStationsGeocode string
+var flagStationsRadius float64
+var flagStationsFormat string
+
+func init() {
+	rootCmd.AddCommand(stationsCmd)
+	stationsCmd.AddCommand(stationsNearCmd)
+
+	stationsCmd.PersistentFlags().StringVar(&flagStationsGeocode, "stations_geocode", "48.029,-118.367", "Location to search near as lat,lon")
+	stationsCmd.PersistentFlags().Float64Var(&flagStationsRadius, "stations_radius", 10, "Search radius in km")
+	stationsCmd.PersistentFlags().StringVar(&flagStationsFormat, "stations_format", "table", "Output format [table,wu_stations]")
+}
+
+// locationNear is the response of /v3/location/near.
+// Each slice holds one value per station, nearest first.
+type locationNear struct {
+	Location struct {
+		StationID   []string  `json:"stationId"`
+		StationName []string  `json:"stationName"`
+		DistanceKm  []float64 `json:"distanceKm"`
+		Latitude    []float64 `json:"latitude"`
+		Longitude   []float64 `json:"longitude"`
+	} `json:"location"`
+}
+
+// nearStation is a station from the location/near response.
+type nearStation struct {
+	id           string
+	neighborhood string
+	distanceKm   float64
+}
+
+// within returns the stations no further than radius km away.
+func (l *locationNear) within(radius float64) []nearStation {
+	var stations []nearStation
+	for i, id := range l.Location.StationID {
+		s := nearStation{id: id}
+		if i < len(l.Location.StationName) {
+			s.neighborhood = l.Location.StationName[i]
+		}
+		if i < len(l.Location.DistanceKm) {
+			s.distanceKm = l.Location.DistanceKm[i]
+		}
+		if s.distanceKm > radius {
+			continue
+		}
+		stations = append(stations, s)
+	}
+	return stations
+}
+
+func (m *managerWU) requestStationsNear(location forecastLocation) (res *locationNear, err error) {
+	payload := url.Values{}
+	payload.Add(location.key, location.value)
+	payload.Add("product", "pws")
+	payload.Add("format", "json")
+	dataBytes, err := m.get("/v3/location/near", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the response body.
+	data := &locationNear{}
+	if len(dataBytes) == 0 {
+		return data, nil
+	}
+	err = json.Unmarshal(dataBytes, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func printStationsTable(w io.Writer, stations []nearStation) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATION\tDISTANCE (km)\tNEIGHBORHOOD")
+	for _, s := range stations {
+		fmt.Fprintf(tw, "%s\t%.1f\t%s\n", s.id, s.distanceKm, s.neighborhood)
+	}
+	tw.Flush()
+}
+
+func printWUStations(w io.Writer, stations []nearStation) {
+	ids := make([]string, len(stations))
+	for i, s := range stations {
+		ids[i] = s.id
+	}
+	fmt.Fprintf(w, "wu_stations: %s\n", strings.Join(ids, ","))
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestStationsNearOutput(t *testing.T) {
+	data := []byte(`{"location":{"stationId":["KWAFRUIT1","KWAHUNTE3","KWAKETTL7"],"stationName":["Fruitland","Hunters","Kettle Falls"],"distanceKm":[0.4,8.2,35.1]}}`)
+	res := &locationNear{}
+	if err := json.Unmarshal(data, res); err != nil {
+		t.Fatal(err)
+	}
+	stations := res.within(10)
+	if len(stations) != 2 {
+		t.Fatalf("want 2 stations within 10km, got %d", len(stations))
+	}
+
+	buf := &bytes.Buffer{}
+	printWUStations(buf, stations)
+	if got := buf.String(); got != "wu_stations: KWAFRUIT1,KWAHUNTE3\n" {
+		t.Errorf("got %q", got)
+	}
+
+	buf.Reset()
+	printStationsTable(buf, stations)
+	if !bytes.Contains(buf.Bytes(), []byte("Hunters")) {
+		t.Errorf("want neighborhood in table, got %q", buf.String())
+	}
+}
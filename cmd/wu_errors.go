@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Classes of Weather Underground API request errors.
+// Each class has its own retry policy.
+const (
+	errClassNetwork     = "network"      // The request did not get a response, eg. timeout, reset or DNS.
+	errClassAuth        = "auth"         // 401/403: the API key is bad or revoked.
+	errClassOffline     = "offline"      // 204: the station has no current observation.
+	errClassRateLimited = "rate_limited" // 429: too many requests.
+	errClassServer      = "server"       // 5xx
+	errClassClient      = "client"       // Any other bad response, eg. 400 or 404.
+)
+
+// wuError is an error from a Weather Underground API request.
+type wuError struct {
+	Class      string
+	StatusCode int
+
+	// RetryAfter is the delay requested by the API's Retry-After header, if any.
+	RetryAfter time.Duration
+
+	Err error
+}
+
+func (e *wuError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("request failed (%s): %v", e.Class, e.Err)
+	}
+	return fmt.Sprintf("request failed (%s): %d", e.Class, e.StatusCode)
+}
+
+func (e *wuError) Unwrap() error {
+	return e.Err
+}
+
+// newStatusError returns the error for a bad response status.
+func newStatusError(response *http.Response) *wuError {
+	e := &wuError{StatusCode: response.StatusCode}
+	switch {
+	case response.StatusCode == http.StatusNoContent:
+		e.Class = errClassOffline
+	case response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden:
+		e.Class = errClassAuth
+	case response.StatusCode == http.StatusTooManyRequests:
+		e.Class = errClassRateLimited
+		e.RetryAfter = parseRetryAfter(response.Header.Get("Retry-After"))
+	case response.StatusCode >= 500:
+		e.Class = errClassServer
+	default:
+		e.Class = errClassClient
+	}
+	return e
+}
+
+// parseRetryAfter parses a Retry-After header, given in seconds or as an HTTP date.
+func parseRetryAfter(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(s); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// retryPolicy is how a class of errors is retried.
+type retryPolicy struct {
+	// tries is the total number of attempts; 1 means no retries.
+	tries int
+
+	// backoff is the wait before the first retry, doubled for each retry after.
+	backoff time.Duration
+}
+
+// parseRetryPolicy parses a policy given as tries,backoff, eg. 3,1s.
+func parseRetryPolicy(s string) (retryPolicy, error) {
+	parts := strings.SplitN(s, ",", 2)
+	if len(parts) != 2 {
+		return retryPolicy{}, fmt.Errorf("retry policy %q is not tries,backoff", s)
+	}
+	tries, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || tries < 1 {
+		return retryPolicy{}, fmt.Errorf("retry policy %q: tries must be at least 1", s)
+	}
+	backoff, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return retryPolicy{}, fmt.Errorf("retry policy %q: %v", s, err)
+	}
+	return retryPolicy{tries: tries, backoff: backoff}, nil
+}
+
+// wait returns the wait before the given retry (1 is the first retry).
+// The API's Retry-After is honored if it is longer.
+func (p retryPolicy) wait(retry int, retryAfter time.Duration) time.Duration {
+	wait := p.backoff << (retry - 1)
+	if retryAfter > wait {
+		return retryAfter
+	}
+	return wait
+}
+
+// defaultRetryPolicies are used for any class without a configured policy.
+var defaultRetryPolicies = map[string]retryPolicy{
+	errClassNetwork:     {tries: 3, backoff: time.Second},
+	errClassAuth:        {tries: 1},
+	errClassOffline:     {tries: 1},
+	errClassRateLimited: {tries: 2, backoff: time.Minute},
+	errClassServer:      {tries: 3, backoff: 5 * time.Second},
+	errClassClient:      {tries: 1},
+}
+
+// parseRetryPolicies parses the retry policy flags, keyed by error class.
+func parseRetryPolicies(specs map[string]string) (map[string]retryPolicy, error) {
+	policies := map[string]retryPolicy{}
+	for class, spec := range specs {
+		p, err := parseRetryPolicy(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", class, err)
+		}
+		policies[class] = p
+	}
+	return policies, nil
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestErrorClasses(t *testing.T) {
+	for _, tc := range []struct {
+		status    int
+		class     string
+		wantTries int
+	}{
+		{http.StatusNoContent, errClassOffline, 2},
+		{http.StatusUnauthorized, errClassAuth, 1},
+		{http.StatusForbidden, errClassAuth, 1},
+		{http.StatusTooManyRequests, errClassRateLimited, 2},
+		{http.StatusServiceUnavailable, errClassServer, 3},
+		{http.StatusNotFound, errClassClient, 1},
+	} {
+		tries := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tries++
+			w.WriteHeader(tc.status)
+		}))
+		m := &managerWU{
+			client:  srv.Client(),
+			baseURL: srv.URL,
+			timeout: time.Second,
+			retryPolicies: map[string]retryPolicy{
+				errClassOffline:     {tries: 2},
+				errClassRateLimited: {tries: 2},
+				errClassServer:      {tries: 3},
+			},
+		}
+		_, err := m.requestCurrent("KWAFRUIT1")
+		srv.Close()
+
+		var wuErr *wuError
+		if !errors.As(err, &wuErr) {
+			t.Errorf("%d: want wuError, got %v", tc.status, err)
+			continue
+		}
+		if wuErr.Class != tc.class {
+			t.Errorf("%d: want class %s, got %s", tc.status, tc.class, wuErr.Class)
+		}
+		if tries != tc.wantTries {
+			t.Errorf("%d: want %d tries, got %d", tc.status, tc.wantTries, tries)
+		}
+	}
+}
+
+func TestRequestRetryStops(t *testing.T) {
+	tries := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tries++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	stop := make(chan struct{})
+	close(stop)
+	m := &managerWU{
+		client:        srv.Client(),
+		baseURL:       srv.URL,
+		timeout:       time.Second,
+		retryPolicies: map[string]retryPolicy{errClassServer: {tries: 3, backoff: time.Hour}},
+		stop:          stop,
+	}
+	start := time.Now()
+	_, err := m.requestCurrent("KWAFRUIT1")
+
+	var wuErr *wuError
+	if !errors.As(err, &wuErr) || wuErr.Class != errClassServer {
+		t.Errorf("want the server error, got %v", err)
+	}
+	if tries != 1 || time.Since(start) > time.Second {
+		t.Errorf("want no retry once stopped, got %d tries in %v", tries, time.Since(start))
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p, err := parseRetryPolicy("4,2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.tries != 4 || p.wait(1, 0) != 2*time.Second || p.wait(3, 0) != 8*time.Second {
+		t.Errorf("unexpected policy: %+v", p)
+	}
+	if got := p.wait(1, time.Minute); got != time.Minute {
+		t.Errorf("want Retry-After honored, got %v", got)
+	}
+	if _, err := parseRetryPolicy("0,1s"); err == nil {
+		t.Error("want error for 0 tries")
+	}
+	if got := parseRetryAfter("120"); got != 2*time.Minute {
+		t.Errorf("Retry-After: got %v", got)
+	}
+}